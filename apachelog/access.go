@@ -1,6 +1,9 @@
 package apachelog
 
 import (
+	"net/http"
+	"net/netip"
+	"net/textproto"
 	"net/url"
 	"strings"
 	"time"
@@ -9,9 +12,10 @@ import (
 // An AccessLogEntry represents a line of an access log.
 type AccessLogEntry struct {
 	RemoteIPAddr        string            // Remote IP address
+	PeerIPAddr          string            // IP address of the peer of the connection, such as a proxy
 	LocalIPAddr         string            // Local IP address
 	ResponseSize        int64             // Size of response in bytes, excluding HTTP headers
-	Cookies             map[string]string // Cookies value
+	Cookies             map[string]string // Cookies value, including those of a logged Cookie header
 	ElapsedTime         int64             // Time taken to serve the request, in microseconds
 	EnvVars             map[string]string // Content of the environment variables
 	Headers             map[string]string // Content of the request headers
@@ -22,11 +26,15 @@ type AccessLogEntry struct {
 	RemoteLogname       string            // Remote logname. A "-" is returned, when not supplied
 	RequestMethod       string            // Request method
 	Port                string            // Canonical port of the server serving the request
+	LocalPort           string            // Actual port of the server serving the request
+	RemotePort          string            // Port of the client
 	ProcessID           int64             // Process ID of the child that serviced the request
+	ThreadID            int64             // Thread ID of the child that serviced the request
 	QueryString         string            // Query string (prepended with a ? if exists)
 	RequestFirstLine    RequestFirstLine  // First line of the request
-	Status              string            // Status
-	Time                time.Time         // Time the request was received (standard english format)
+	Status              string            // Status, as logged
+	StatusCode          StatusCode        // Status code, unset if the status is not a number
+	Time                time.Time         // Time the request was received, with sub-second precision if logged
 	ElapsedTimeSec      int64             // Time taken to serve the request, in seconds
 	RemoteUser          string            // Remote user (from auth)
 	URLPath             string            // URL path requested, not including any query string
@@ -34,6 +42,140 @@ type AccessLogEntry struct {
 	ServerName          string            // Server name according to the UseCanonicalName setting
 	BytesReceived       int64             // Bytes received, including request and headers
 	BytesSent           int64             // Bytes sent, including headers
+
+	present uint64 // formats for which a value has been logged, see Has
+}
+
+// Has reports whether a value has been logged for the given format, that is
+// the line held a value other than the "-" that Apache writes for missing
+// ones. It tells a missing value from a zero or empty one, such as a missing
+// response size from an empty response for %b.
+//
+// String fields, as well as the maps of headers, cookies and environment
+// variables, hold the values as logged, "-" included. For the maps, Has
+// reports whether at least one value has been logged, and HasHeader,
+// HasCookie and HasEnvVar tell which ones.
+func (e *AccessLogEntry) Has(f Format) bool {
+	return f > format_beg && f < format_end && e.present&(1<<f) != 0
+}
+
+// HasHeader reports whether a value other than "-" has been logged for the
+// given request header, whose name is matched case-insensitively.
+func (e *AccessLogEntry) HasHeader(name string) bool {
+	v, ok := e.lookupHeader(name)
+	return ok && v != "-"
+}
+
+// HasCookie reports whether a value other than "-" has been logged for the
+// given cookie.
+func (e *AccessLogEntry) HasCookie(name string) bool {
+	v, ok := e.Cookies[name]
+	return ok && v != "-"
+}
+
+// HasEnvVar reports whether a value other than "-" has been logged for the
+// given environment variable.
+func (e *AccessLogEntry) HasEnvVar(name string) bool {
+	v, ok := e.EnvVars[name]
+	return ok && v != "-"
+}
+
+// Header returns the value of the given request header as logged, or an empty
+// string if it is not part of the format. Unlike the Headers map, which is
+// keyed by the header names as spelled in the format, such as "User-agent",
+// the name is matched case-insensitively.
+func (e *AccessLogEntry) Header(name string) string {
+	v, _ := e.lookupHeader(name)
+	return v
+}
+
+// lookupHeader returns the value of the given request header, matching its
+// name case-insensitively, and whether it has been found.
+func (e *AccessLogEntry) lookupHeader(name string) (string, bool) {
+	if v, ok := e.Headers[name]; ok {
+		return v, true
+	}
+	if v, ok := e.Headers[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		return v, true
+	}
+	for k, v := range e.Headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// HTTPHeader returns the logged request headers as an http.Header, whose keys
+// are canonicalized.
+func (e *AccessLogEntry) HTTPHeader() http.Header {
+	h := make(http.Header, len(e.Headers))
+	for k, v := range e.Headers {
+		h.Add(k, v)
+	}
+	return h
+}
+
+// Referer returns the value of the Referer request header, as Header does.
+func (e *AccessLogEntry) Referer() string {
+	return e.Header("Referer")
+}
+
+// UserAgent returns the value of the User-Agent request header, as Header
+// does.
+func (e *AccessLogEntry) UserAgent() string {
+	return e.Header("User-Agent")
+}
+
+// RemoteAddr returns the remote IP address (%a) as a netip.Addr, including its
+// zone for scoped IPv6 addresses. The returned address is invalid if the
+// logged value is not an IP address.
+func (e *AccessLogEntry) RemoteAddr() netip.Addr {
+	return parseAddr(e.RemoteIPAddr)
+}
+
+// PeerAddr returns the IP address of the peer of the connection (%{c}a) as a
+// netip.Addr. The returned address is invalid if the logged value is not an
+// IP address.
+func (e *AccessLogEntry) PeerAddr() netip.Addr {
+	return parseAddr(e.PeerIPAddr)
+}
+
+// LocalAddr returns the local IP address (%A) as a netip.Addr. The returned
+// address is invalid if the logged value is not an IP address.
+func (e *AccessLogEntry) LocalAddr() netip.Addr {
+	return parseAddr(e.LocalIPAddr)
+}
+
+// RemoteHostAddr returns the remote host (%h) as a netip.Addr. The returned
+// address is invalid if the remote host is a hostname, which Apache logs
+// instead of the address when HostnameLookups is on and the reverse lookup
+// succeeded. No lookup is ever performed.
+func (e *AccessLogEntry) RemoteHostAddr() netip.Addr {
+	return parseAddr(e.RemoteHost)
+}
+
+// parseAddr parses an IP address, optionally enclosed in square brackets, or
+// returns the zero netip.Addr if s is not an IP address.
+func parseAddr(s string) netip.Addr {
+	if len(s) > 2 && s[0] == '[' && s[len(s)-1] == ']' {
+		s = s[1 : len(s)-1]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// reset clears the entry so that it can be reused, keeping its maps
+// allocated.
+func (e *AccessLogEntry) reset() {
+	cookies, headers, envVars := e.Cookies, e.Headers, e.EnvVars
+	clear(cookies)
+	clear(headers)
+	clear(envVars)
+	*e = AccessLogEntry{Cookies: cookies, Headers: headers, EnvVars: envVars}
 }
 
 // RequestFirstLine is a handy structure to hold the first line of the HTTP
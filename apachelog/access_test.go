@@ -1,6 +1,10 @@
 package apachelog
 
-import "testing"
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
 
 func TestNewRequestFirstLine(t *testing.T) {
 	if got := NewRequestFirstLine("foo"); got.raw != "foo" {
@@ -81,3 +85,127 @@ func TestRequestFirstLine_parse(t *testing.T) {
 		}
 	}
 }
+
+func TestAccessLogEntry_Has(t *testing.T) {
+	l := MustCompile(`%h %l %u %>s %b "%{Referer}i" %{SESSID}C %!200B`)
+	testCases := []struct {
+		line    string
+		present []Format
+		absent  []Format
+	}{
+		{
+			line:    `127.0.0.1 - frank 200 0 "http://example.com/" abc -`,
+			present: []Format{REMOTE_HOST, REMOTE_USER, STATUS, RESPONSE_SIZE_CLF, HEADER, COOKIE},
+			absent:  []Format{REMOTE_LOGNAME, RESPONSE_SIZE, TIME, UNKNOWN},
+		},
+		{
+			line:    `127.0.0.1 - - 404 - "-" - 9`,
+			present: []Format{REMOTE_HOST, STATUS, RESPONSE_SIZE},
+			absent:  []Format{REMOTE_LOGNAME, REMOTE_USER, RESPONSE_SIZE_CLF, HEADER, COOKIE},
+		},
+	}
+	for _, tc := range testCases {
+		entry, err := l.ParseLine(tc.line)
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", tc.line, err.Error())
+			continue
+		}
+		for _, f := range tc.present {
+			if !entry.Has(f) {
+				t.Errorf("ParseLine(%q).Has(%v): got false; want true", tc.line, f)
+			}
+		}
+		for _, f := range tc.absent {
+			if entry.Has(f) {
+				t.Errorf("ParseLine(%q).Has(%v): got true; want false", tc.line, f)
+			}
+		}
+		if got, want := entry.HasHeader("referer"), entry.Has(HEADER); got != want {
+			t.Errorf("ParseLine(%q).HasHeader(%q): got %t; want %t", tc.line, "referer", got, want)
+		}
+		if got, want := entry.HasCookie("SESSID"), entry.Has(COOKIE); got != want {
+			t.Errorf("ParseLine(%q).HasCookie(%q): got %t; want %t", tc.line, "SESSID", got, want)
+		}
+		if _, found := entry.Headers["Referer"]; !found {
+			t.Errorf("ParseLine(%q): Referer not found; want the logged value", tc.line)
+		}
+	}
+}
+
+func TestAccessLogEntry_RemoteAddr(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want string // empty for an invalid address
+	}{
+		{"192.168.1.10", "192.168.1.10"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1%eth0"},
+		{"www.example.com", ""},
+		{"-", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		entry := AccessLogEntry{RemoteIPAddr: tc.in, LocalIPAddr: tc.in, RemoteHost: tc.in}
+		for name, addr := range map[string]netip.Addr{
+			"RemoteAddr":     entry.RemoteAddr(),
+			"LocalAddr":      entry.LocalAddr(),
+			"RemoteHostAddr": entry.RemoteHostAddr(),
+		} {
+			var got string
+			if addr.IsValid() {
+				got = addr.String()
+			}
+			if got != tc.want {
+				t.Errorf("%s() of %q: got %q; want %q", name, tc.in, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestAccessLogEntry_Header(t *testing.T) {
+	entry := AccessLogEntry{Headers: map[string]string{
+		"User-agent":      "curl/7.50",
+		"referer":         "http://example.com/",
+		"X-FORWARDED-FOR": "10.0.0.1",
+	}}
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{"User-Agent", "curl/7.50"},
+		{"user-agent", "curl/7.50"},
+		{"Referer", "http://example.com/"},
+		{"X-Forwarded-For", "10.0.0.1"},
+		{"Cookie", ""},
+	}
+	for _, tc := range testCases {
+		if got := entry.Header(tc.name); got != tc.want {
+			t.Errorf("Header(%q): got %q; want %q", tc.name, got, tc.want)
+		}
+		if got := entry.HTTPHeader().Get(tc.name); got != tc.want {
+			t.Errorf("HTTPHeader().Get(%q): got %q; want %q", tc.name, got, tc.want)
+		}
+	}
+	if got, want := entry.UserAgent(), "curl/7.50"; got != want {
+		t.Errorf("UserAgent(): got %q; want %q", got, want)
+	}
+	if got, want := entry.Referer(), "http://example.com/"; got != want {
+		t.Errorf("Referer(): got %q; want %q", got, want)
+	}
+}
+
+func TestAccessLogEntry_cookies(t *testing.T) {
+	l := MustCompile(`%h "%{Cookie}i" %{lang}C`)
+	entry, err := l.ParseLine(`127.0.0.1 "session=abc123; theme=\"dark\"; lang=en; invalid" fr`)
+	if err != nil {
+		t.Fatalf("ParseLine(): unexpected error %q", err.Error())
+	}
+	want := map[string]string{"session": "abc123", "theme": "dark", "lang": "fr"}
+	if !reflect.DeepEqual(entry.Cookies, want) {
+		t.Errorf("ParseLine(): got Cookies %q; want %q", entry.Cookies, want)
+	}
+	if got, want := entry.Header("cookie"), `session=abc123; theme="dark"; lang=en; invalid`; got != want {
+		t.Errorf("ParseLine(): got Cookie header %q; want %q", got, want)
+	}
+}
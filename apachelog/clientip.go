@@ -0,0 +1,121 @@
+package apachelog
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// A ClientIPResolver determines the address of the client that sent a request
+// through trusted proxies, such as load balancers, the same way the
+// mod_remoteip Apache module does.
+//
+// The proxies report the addresses they forward requests for using the
+// Forwarded (RFC 7239) or X-Forwarded-For request header, which must be
+// logged, for instance using %{X-Forwarded-For}i. The header chain is walked
+// from the nearest proxy, starting with the remote IP address (%a, or else the
+// peer IP address %{c}a or the remote host %h), and the first address that is
+// not a trusted proxy is the client.
+//
+// A ClientIPResolver is safe for concurrent use.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+// NewClientIPResolver creates a new resolver trusting the proxies having the
+// given addresses, either in CIDR notation, such as "10.0.0.0/8", or single IP
+// addresses.
+func NewClientIPResolver(trusted ...string) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{trusted: make([]netip.Prefix, 0, len(trusted))}
+	for _, s := range trusted {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", s, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", s, err)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// ClientIP returns the address of the client that sent the request of the
+// given entry. The returned address is invalid if none of the remote IP
+// address, the peer IP address and the remote host of the entry is an IP
+// address.
+//
+// The Forwarded header is used if logged, X-Forwarded-For otherwise. Walking
+// the chain stops at the first value that is not a valid address, such as
+// "unknown" or an obfuscated identifier, in which case the last valid address
+// is returned.
+func (r *ClientIPResolver) ClientIP(entry *AccessLogEntry) netip.Addr {
+	addr := entry.RemoteAddr()
+	if !addr.IsValid() {
+		addr = entry.PeerAddr()
+	}
+	if !addr.IsValid() {
+		addr = entry.RemoteHostAddr()
+	}
+	if !addr.IsValid() || !r.isTrusted(addr) {
+		return addr
+	}
+
+	chain := forwardedFor(entry.Header("Forwarded"))
+	if chain == nil {
+		chain = strings.Split(entry.Header("X-Forwarded-For"), ",")
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		next := parseNodeAddr(strings.TrimSpace(chain[i]))
+		if !next.IsValid() {
+			break
+		}
+		addr = next
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// isTrusted reports whether addr is the address of a trusted proxy.
+func (r *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the nodes identified by the for parameters of the
+// given Forwarded header (RFC 7239), in order, or nil if there are none.
+func forwardedFor(header string) []string {
+	var nodes []string
+	for _, elem := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				nodes = append(nodes, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return nodes
+}
+
+// parseNodeAddr parses the address of a node identified by a proxy header,
+// which may include a port, such as "192.0.2.43:47011" or
+// "[2001:db8:cafe::17]:4711". It returns an invalid address if the node is
+// not identified by an IP address.
+func parseNodeAddr(s string) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr()
+	}
+	return parseAddr(s)
+}
@@ -0,0 +1,62 @@
+package apachelog
+
+import "testing"
+
+func TestClientIPResolver(t *testing.T) {
+	r, err := NewClientIPResolver("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("NewClientIPResolver(): unexpected error %q", err.Error())
+	}
+	testCases := []struct {
+		remote  string
+		host    string
+		headers map[string]string
+		want    string
+	}{
+		// Untrusted peers are the clients, regardless of the headers.
+		{"203.0.113.7", "", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"10.0.0.1", "", nil, "10.0.0.1"},
+		{"10.0.0.1", "", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1", "", map[string]string{"x-forwarded-for": "198.51.100.1, 203.0.113.7, 192.168.1.1"}, "203.0.113.7"},
+		{"10.0.0.1", "", map[string]string{"X-Forwarded-For": "10.1.2.3, 10.4.5.6"}, "10.1.2.3"},
+		{"10.0.0.1", "", map[string]string{"X-Forwarded-For": "198.51.100.1, unknown, 10.4.5.6"}, "10.4.5.6"},
+		{"10.0.0.1", "", map[string]string{"X-Forwarded-For": "198.51.100.1:4711"}, "198.51.100.1"},
+		{"", "10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"", "proxy.example.com", map[string]string{"X-Forwarded-For": "198.51.100.1"}, ""},
+		{"2001:db8::1", "", map[string]string{"X-Forwarded-For": "2001:db9::1"}, "2001:db9::1"},
+		{"::ffff:10.0.0.1", "", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1", "", map[string]string{
+			"Forwarded":       `for=198.51.100.1;proto=https, for="[2001:db9::17]:4711"`,
+			"X-Forwarded-For": "203.0.113.7",
+		}, "2001:db9::17"},
+		{"10.0.0.1", "", map[string]string{"Forwarded": "proto=https", "X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+	}
+	for _, tc := range testCases {
+		entry := AccessLogEntry{RemoteIPAddr: tc.remote, RemoteHost: tc.host, Headers: tc.headers}
+		var got string
+		if addr := r.ClientIP(&entry); addr.IsValid() {
+			got = addr.String()
+		}
+		if got != tc.want {
+			t.Errorf("ClientIP(%q, %q, %q): got %q; want %q", tc.remote, tc.host, tc.headers, got, tc.want)
+		}
+	}
+
+	// The peer IP address is used when the remote IP address is not logged.
+	entry := AccessLogEntry{
+		PeerIPAddr: "10.0.0.1",
+		RemoteHost: "203.0.113.7",
+		Headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+	}
+	if got, want := r.ClientIP(&entry).String(), "198.51.100.1"; got != want {
+		t.Errorf("ClientIP(%+v): got %q; want %q", entry, got, want)
+	}
+}
+
+func TestNewClientIPResolver_invalid(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "proxy", "10.0.0/8"} {
+		if _, err := NewClientIPResolver(s); err == nil {
+			t.Errorf("NewClientIPResolver(%q): expected error; got none", s)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package apachelog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A ServerConfig holds the access log formats defined in the configuration of
+// an Apache HTTP server.
+type ServerConfig struct {
+	// Formats maps the nicknames defined by LogFormat directives to their
+	// format strings.
+	Formats map[string]string
+
+	// DefaultFormat is the format set by the last LogFormat directive without
+	// nickname, used by TransferLog directives. The argument of such a
+	// directive is resolved as a nickname first. It defaults to the Common Log
+	// format, as in Apache.
+	DefaultFormat string
+
+	// Logs lists the CustomLog and TransferLog directives, in the order of
+	// the configuration.
+	Logs []LogConfig
+}
+
+// A LogConfig describes an access log written by an Apache HTTP server, as
+// declared by a CustomLog or TransferLog directive.
+type LogConfig struct {
+	Path      string // File, or piped command when starting with "|"
+	Nickname  string // Nickname of the format, empty when given as is
+	Format    string // Format string of the log entries
+	Condition string // Condition of the entries, such as "env=!dontlog"
+	File      string // Configuration file declaring the log
+	Line      int    // Line of the declaration in the configuration file
+}
+
+// ReadServerConfig reads the LogFormat, CustomLog and TransferLog directives
+// from the Apache configuration file at path, as well as from the files it
+// includes using Include and IncludeOptional directives. Relative paths are
+// resolved against the ServerRoot directive, or against the directory of the
+// configuration file when there is none.
+//
+// Sections such as <VirtualHost> or <IfModule> are not evaluated: all the
+// directives they contain are read.
+func ReadServerConfig(path string) (*ServerConfig, error) {
+	cr := &configReader{
+		root: filepath.Dir(path),
+		cfg: &ServerConfig{
+			Formats:       make(map[string]string),
+			DefaultFormat: CommonLogFormat,
+		},
+	}
+	if err := cr.readFile(path, 0); err != nil {
+		return nil, err
+	}
+
+	// As in Apache, nicknames are resolved once the whole configuration has
+	// been read, a format given as is being used when no nickname matches.
+	for i := range cr.cfg.Logs {
+		log := &cr.cfg.Logs[i]
+		if log.Format != "" {
+			continue
+		}
+		if format, ok := cr.cfg.Formats[log.Nickname]; ok {
+			log.Format = format
+		} else {
+			log.Format, log.Nickname = log.Nickname, ""
+		}
+	}
+	return cr.cfg, nil
+}
+
+// Format returns the format string having the given nickname.
+func (c *ServerConfig) Format(nickname string) (string, bool) {
+	format, ok := c.Formats[nickname]
+	return format, ok
+}
+
+// NewParser creates a new parser reading log entries from r that have the
+// format of the given nickname.
+func (c *ServerConfig) NewParser(r io.Reader, nickname string, opts ...Option) (*Parser, error) {
+	format, ok := c.Formats[nickname]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format nickname %q", nickname)
+	}
+	return CustomParser(r, format, opts...)
+}
+
+// maxIncludeDepth limits the nesting of Include directives, to detect loops.
+const maxIncludeDepth = 32
+
+// A configReader reads the directives of an Apache configuration tree.
+type configReader struct {
+	root string
+	cfg  *ServerConfig
+}
+
+func (cr *configReader) readFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	var n, start int
+	var line string
+	for sc.Scan() {
+		n++
+		if line == "" {
+			start = n
+		}
+		line += strings.TrimSpace(sc.Text())
+		// A trailing backslash continues the directive on the next line.
+		if strings.HasSuffix(line, "\\") {
+			line = line[:len(line)-1]
+			continue
+		}
+		if line != "" && line[0] != '#' && line[0] != '<' {
+			if err := cr.directive(path, start, line, depth); err != nil {
+				return err
+			}
+		}
+		line = ""
+	}
+	return sc.Err()
+}
+
+// directive handles a single directive declared at the given line of the
+// configuration file at path.
+func (cr *configReader) directive(path string, line int, s string, depth int) error {
+	name, args, err := splitDirective(s)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %v", path, line, err)
+	}
+	switch strings.ToLower(name) {
+	case "serverroot":
+		if len(args) == 1 {
+			cr.root = args[0]
+		}
+	case "include", "includeoptional":
+		if len(args) != 1 {
+			return fmt.Errorf("%s:%d: %s takes one argument", path, line, name)
+		}
+		return cr.include(path, line, args[0], strings.EqualFold(name, "includeoptional"), depth)
+	case "logformat":
+		switch len(args) {
+		case 1:
+			// The argument is either a nickname or a format string.
+			format, ok := cr.cfg.Formats[args[0]]
+			if !ok {
+				format = args[0]
+			}
+			cr.cfg.DefaultFormat = format
+		case 2:
+			cr.cfg.Formats[args[1]] = args[0]
+		default:
+			return fmt.Errorf("%s:%d: LogFormat takes one or two arguments", path, line)
+		}
+	case "customlog":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("%s:%d: CustomLog takes two or three arguments", path, line)
+		}
+		log := LogConfig{Path: args[0], Nickname: args[1], File: path, Line: line}
+		if len(args) == 3 {
+			log.Condition = args[2]
+		}
+		cr.cfg.Logs = append(cr.cfg.Logs, log)
+	case "transferlog":
+		if len(args) != 1 {
+			return fmt.Errorf("%s:%d: TransferLog takes one argument", path, line)
+		}
+		cr.cfg.Logs = append(cr.cfg.Logs, LogConfig{
+			Path:   args[0],
+			Format: cr.cfg.DefaultFormat,
+			File:   path,
+			Line:   line,
+		})
+	}
+	return nil
+}
+
+// include reads the configuration files matching pattern. Missing files are
+// an error unless optional is set or the pattern contains wildcards.
+func (cr *configReader) include(path string, line int, pattern string, optional bool, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s:%d: too many nested includes", path, line)
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(cr.root, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %v", path, line, err)
+	}
+	if len(matches) == 0 && !optional && !strings.ContainsAny(pattern, "*?[") {
+		return fmt.Errorf("%s:%d: no such file %q", path, line, pattern)
+	}
+	sort.Strings(matches)
+	for _, match := range matches {
+		if fi, err := os.Stat(match); err == nil && fi.IsDir() {
+			// Apache includes every file of a directory.
+			if err := cr.include(path, line, filepath.Join(match, "*"), true, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cr.readFile(match, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitDirective splits a configuration directive into its name and its
+// arguments. Arguments may be enclosed in double or single quotes, in which
+// case the quote character and the backslash can be escaped using a backslash.
+func splitDirective(s string) (name string, args []string, err error) {
+	var words []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		quote := s[0]
+		if quote != '"' && quote != '\'' {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			words = append(words, s[:end])
+			s = s[end:]
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != quote; i++ {
+			if s[i] == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\') {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return "", nil, fmt.Errorf("unterminated quoted argument in %q", s)
+		}
+		words = append(words, b.String())
+		s = s[i+1:]
+	}
+	return words[0], words[1:], nil
+}
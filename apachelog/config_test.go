@@ -0,0 +1,152 @@
+package apachelog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "httpd.conf", `# Main configuration
+ServerRoot "`+dir+`"
+<IfModule log_config_module>
+    LogFormat "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\"" combined
+    LogFormat "%h %l %u %t \"%r\" %>s %b" common
+    LogFormat '%v:%p %h %l %u %t "%r" %>s %O' vhost
+    CustomLog "logs/access_log" common
+</IfModule>
+Include conf/extra/*.conf
+IncludeOptional conf.d/*.conf
+TransferLog logs/transfer_log
+`)
+	writeConfig(t, dir, "conf/extra/vhosts.conf", `<VirtualHost *:80>
+    CustomLog "|/usr/bin/rotatelogs logs/site_log 86400" \
+        combined env=!dontlog
+    CustomLog logs/referer_log "%{Referer}i -> %U"
+</VirtualHost>
+`)
+
+	cfg, err := ReadServerConfig(path)
+	if err != nil {
+		t.Fatalf("ReadServerConfig(): unexpected error %q", err.Error())
+	}
+	const (
+		combined = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+		common   = `%h %l %u %t "%r" %>s %b`
+	)
+	wantFormats := map[string]string{
+		"combined": combined,
+		"common":   common,
+		"vhost":    `%v:%p %h %l %u %t "%r" %>s %O`,
+	}
+	if !reflect.DeepEqual(cfg.Formats, wantFormats) {
+		t.Errorf("ReadServerConfig(): got formats %q, want %q", cfg.Formats, wantFormats)
+	}
+	vhosts := filepath.Join(dir, "conf/extra/vhosts.conf")
+	wantLogs := []LogConfig{
+		{Path: "logs/access_log", Nickname: "common", Format: common, File: path, Line: 7},
+		{Path: "|/usr/bin/rotatelogs logs/site_log 86400", Nickname: "combined", Format: combined, Condition: "env=!dontlog", File: vhosts, Line: 2},
+		{Path: "logs/referer_log", Format: "%{Referer}i -> %U", File: vhosts, Line: 4},
+		{Path: "logs/transfer_log", Format: CommonLogFormat, File: path, Line: 11},
+	}
+	if !reflect.DeepEqual(cfg.Logs, wantLogs) {
+		t.Errorf("ReadServerConfig(): got logs %+v, want %+v", cfg.Logs, wantLogs)
+	}
+}
+
+func TestReadServerConfig_defaultFormat(t *testing.T) {
+	const combined = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+	testCases := []struct {
+		content string
+		want    string
+	}{
+		{
+			content: "LogFormat \"" + strings.ReplaceAll(combined, `"`, `\"`) + "\" combined\nLogFormat combined\nTransferLog logs/x\n",
+			want:    combined,
+		},
+		{
+			content: "LogFormat \"%h %>s\"\nTransferLog logs/x\n",
+			want:    "%h %>s",
+		},
+		{
+			content: "LogFormat unknown\nTransferLog logs/x\n",
+			want:    "unknown",
+		},
+	}
+	for _, test := range testCases {
+		path := writeConfig(t, t.TempDir(), "httpd.conf", test.content)
+		cfg, err := ReadServerConfig(path)
+		if err != nil {
+			t.Errorf("ReadServerConfig(%q): unexpected error %q", test.content, err.Error())
+			continue
+		}
+		if got := cfg.Logs[0].Format; got != test.want {
+			t.Errorf("ReadServerConfig(%q): got format %q; want %q", test.content, got, test.want)
+		}
+	}
+}
+
+func TestReadServerConfig_errors(t *testing.T) {
+	testCases := []string{
+		"Include missing.conf\n",
+		"LogFormat \"%h\n",
+		"LogFormat a b c\n",
+		"CustomLog logs/access_log\n",
+		"Include httpd.conf\n",
+	}
+	for _, content := range testCases {
+		path := writeConfig(t, t.TempDir(), "httpd.conf", content)
+		if _, err := ReadServerConfig(path); err == nil {
+			t.Errorf("ReadServerConfig(%q): expected error; got none", content)
+		}
+	}
+}
+
+func TestReadServerConfig_directoryLoop(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "httpd.conf", "ServerRoot "+dir+"\nInclude conf.d\n")
+	writeConfig(t, dir, "conf.d/log.conf", "TransferLog logs/x\n")
+	if err := os.Symlink(filepath.Join(dir, "conf.d"), filepath.Join(dir, "conf.d", "loop")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if _, err := ReadServerConfig(path); err == nil || !strings.Contains(err.Error(), "too many nested includes") {
+		t.Errorf("ReadServerConfig(): got error %v; want too many nested includes", err)
+	}
+}
+
+func TestServerConfig_NewParser(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "httpd.conf", `LogFormat "%h %>s" short`+"\n")
+	cfg, err := ReadServerConfig(path)
+	if err != nil {
+		t.Fatalf("ReadServerConfig(): unexpected error %q", err.Error())
+	}
+	if _, err := cfg.NewParser(strings.NewReader(""), "combined"); err == nil {
+		t.Errorf("NewParser(%q): expected error; got none", "combined")
+	}
+	p, err := cfg.NewParser(strings.NewReader("127.0.0.1 404\n"), "short")
+	if err != nil {
+		t.Fatalf("NewParser(%q): unexpected error %q", "short", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse(): unexpected error %q", err.Error())
+	}
+	if entry.RemoteHost != "127.0.0.1" || entry.Status != "404" {
+		t.Errorf("Parse(): got %q and %q", entry.RemoteHost, entry.Status)
+	}
+}
@@ -0,0 +1,162 @@
+package apachelog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// DetectionSampleSize is the number of lines sampled by DetectFormat.
+const DetectionSampleSize = 100
+
+// minDetectionDirectives is the number of directives a format must have to be
+// detected. Formats having fewer directives, such as the agent one, match
+// almost any line.
+const minDetectionDirectives = 2
+
+// A Detection is the outcome of the detection of the format of a log.
+type Detection struct {
+	Format     string  // Format matching the sampled lines best
+	Name       string  // Name of the format, if registered
+	Confidence float64 // Fraction of the sampled lines matching the format, from 0 to 1
+	Lines      int     // Number of sampled lines
+}
+
+// DetectFormat samples the first lines of r, up to DetectionSampleSize, and
+// returns the format matching them best. The given candidate formats are tried
+// first, followed by the registered formats (see Register).
+//
+// A line matches a format if the entry parsed from it renders exactly as the
+// original line when formatted back using the same format, and if its typed
+// values are plausible: the status must be a valid HTTP status code and the
+// request line must end with an HTTP protocol. The confidence is the fraction
+// of the sampled lines matching the format. When several formats match
+// equally, the most specific one, having the most directives, wins. Formats
+// having a single directive are never detected, since they match almost any
+// line.
+//
+// An error is returned if no format matches any of the sampled lines.
+func DetectFormat(r io.Reader, candidates ...string) (*Detection, error) {
+	d, _, err := detect(bufio.NewReader(r), candidates)
+	return d, err
+}
+
+// SniffParser detects the format of the log read from r, as DetectFormat
+// does, and returns a parser for this format. The sampled lines are not lost:
+// the parser starts from the first line of r.
+func SniffParser(r io.Reader, candidates ...string) (*Parser, *Detection, error) {
+	br := bufio.NewReader(r)
+	d, sample, err := detect(br, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := CustomParser(io.MultiReader(bytes.NewReader(sample), br), d.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, d, nil
+}
+
+// detect samples the first lines read from br and returns the format matching
+// them best, along with the sampled data.
+func detect(br *bufio.Reader, candidates []string) (*Detection, []byte, error) {
+	var sample []byte
+	var lines []string
+	for len(lines) < DetectionSampleSize {
+		line, err := br.ReadString('\n')
+		sample = append(sample, line...)
+		if line = trimEOL(line); line != "" {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil, errors.New("no lines to detect the format from")
+	}
+
+	type candidate struct{ name, format string }
+	all := make([]candidate, 0, len(candidates))
+	for _, format := range candidates {
+		all = append(all, candidate{format: format})
+	}
+	for _, name := range FormatNames() {
+		format, _ := NamedFormat(name)
+		all = append(all, candidate{name, format})
+	}
+
+	var best *Detection
+	var bestDirectives int
+	for _, c := range all {
+		matches, directives, err := matchFormat(c.format, lines)
+		if err != nil {
+			return nil, nil, err
+		}
+		if matches == 0 {
+			continue
+		}
+		confidence := float64(matches) / float64(len(lines))
+		if best == nil || confidence > best.Confidence ||
+			(confidence == best.Confidence && directives > bestDirectives) {
+			best = &Detection{Format: c.format, Name: c.name, Confidence: confidence, Lines: len(lines)}
+			bestDirectives = directives
+		}
+	}
+	if best == nil {
+		return nil, nil, errors.New("unknown log format")
+	}
+	return best, sample, nil
+}
+
+// matchFormat returns the number of lines matching the given format, as well
+// as the number of directives of the format.
+func matchFormat(format string, lines []string) (matches, directives int, err error) {
+	l, err := Compile(format)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := NewFormatter(format)
+	if err != nil {
+		return 0, 0, err
+	}
+	tokens, _ := lexFormat(format)
+	for _, tok := range tokens {
+		if tok.typ == tokenDirective {
+			directives++
+		}
+	}
+	if directives < minDetectionDirectives {
+		return 0, directives, nil
+	}
+
+	var entry AccessLogEntry
+	var buf []byte
+	for _, line := range lines {
+		if err := l.parseLine(&entry, line); err != nil || !isPlausible(&entry) {
+			continue
+		}
+		if buf = f.AppendFormat(buf[:0], &entry); string(buf) == line {
+			matches++
+		}
+	}
+	return matches, directives, nil
+}
+
+// isPlausible reports whether the typed values of the entry, when logged, are
+// valid ones, which loose formats would not check otherwise: %s accepts any
+// value and so would %r.
+func isPlausible(entry *AccessLogEntry) bool {
+	if entry.Has(STATUS) && (entry.StatusCode < 100 || entry.StatusCode > 599) {
+		return false
+	}
+	if entry.Has(REQUEST_FIRST_LINE) && !strings.HasPrefix(entry.RequestFirstLine.Protocol(), "HTTP/") {
+		return false
+	}
+	return true
+}
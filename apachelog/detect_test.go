@@ -0,0 +1,112 @@
+package apachelog
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	const (
+		common   = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`
+		combined = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+		vhost    = `www.example.com:443 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2520 "-" "curl/7.50.1"`
+		custom   = `127.0.0.1 [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 1234`
+	)
+	testCases := []struct {
+		input      string
+		candidates []string
+		name       string
+		format     string
+		confidence float64
+	}{
+		{common + "\n" + common + "\n", nil, "common", CommonLogFormat, 1},
+		{combined + "\n" + combined, nil, "combined", CombinedLogFormat, 1},
+		{combined + "\n" + combined + "\n" + common + "\n\n" + combined + "\n", nil, "combined", CombinedLogFormat, 0.75},
+		{vhost + "\n", nil, "vhost_combined", VHostCombinedLogFormat, 1},
+		{combined + ` 10 20` + "\n", nil, "combinedio", CombinedIOLogFormat, 1},
+		{combined + ` "foo=bar"` + "\n", nil, "ncsa_extended_cookie", NCSAExtendedCookieLogFormat, 1},
+		{"http://www.example.com/ -> /index.html\n", nil, "referer", RefererLogFormat, 1},
+		{custom + "\n" + custom, []string{`%h %t "%r" %>s %D`}, "", `%h %t "%r" %>s %D`, 1},
+	}
+	for _, tc := range testCases {
+		d, err := DetectFormat(strings.NewReader(tc.input), tc.candidates...)
+		if err != nil {
+			t.Errorf("DetectFormat(%q): unexpected error %q", tc.input, err.Error())
+			continue
+		}
+		if d.Name != tc.name {
+			t.Errorf("DetectFormat(%q): got name %q, want %q", tc.input, d.Name, tc.name)
+		}
+		if d.Format != tc.format {
+			t.Errorf("DetectFormat(%q): got format %q, want %q", tc.input, d.Format, tc.format)
+		}
+		if d.Confidence != tc.confidence {
+			t.Errorf("DetectFormat(%q): got confidence %v, want %v", tc.input, d.Confidence, tc.confidence)
+		}
+	}
+}
+
+func TestDetectFormat_unknown(t *testing.T) {
+	for _, input := range []string{"", "\n\n", "foo bar\n", "foobar\n", "foobar\nGARBAGE\n"} {
+		if _, err := DetectFormat(strings.NewReader(input)); err == nil {
+			t.Errorf("DetectFormat(%q): expected error; got none", input)
+		}
+	}
+
+	testCases := []struct {
+		input     string
+		candidate string
+	}{
+		{"127.0.0.1 bar\n", "%h %>s"},
+		{"127.0.0.1 999\n", "%h %>s"},
+		{"127.0.0.1 \"foo bar baz\"\n", `%h "%r"`},
+		{"foo\n", "%h"},
+	}
+	for _, tc := range testCases {
+		if d, err := DetectFormat(strings.NewReader(tc.input), tc.candidate); err == nil {
+			t.Errorf("DetectFormat(%q, %q): expected error; got format %q", tc.input, tc.candidate, d.Format)
+		}
+	}
+}
+
+func TestDetectFormat_sampleSize(t *testing.T) {
+	const line = `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`
+	input := strings.Repeat(line+"\n", DetectionSampleSize) + "garbage\n"
+	d, err := DetectFormat(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("DetectFormat(): unexpected error %q", err.Error())
+	}
+	if d.Lines != DetectionSampleSize || d.Confidence != 1 {
+		t.Errorf("DetectFormat(): got %d lines and confidence %v, want %d and 1", d.Lines, d.Confidence, DetectionSampleSize)
+	}
+}
+
+func TestSniffParser(t *testing.T) {
+	const line = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "-" "curl/7.50.1"`
+	input := strings.Repeat(line+"\n", DetectionSampleSize+10)
+	p, d, err := SniffParser(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("SniffParser(): unexpected error %q", err.Error())
+	}
+	if d.Format != CombinedLogFormat {
+		t.Errorf("SniffParser(): got format %q, want %q", d.Format, CombinedLogFormat)
+	}
+	var n int
+	for {
+		entry, err := p.Parse()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Parse(): unexpected error %q", err.Error())
+		}
+		if entry.Headers["User-agent"] != "curl/7.50.1" {
+			t.Errorf("Parse(): got User-agent %q", entry.Headers["User-agent"])
+		}
+		n++
+	}
+	if n != DetectionSampleSize+10 {
+		t.Errorf("SniffParser(): got %d entries, want %d", n, DetectionSampleSize+10)
+	}
+}
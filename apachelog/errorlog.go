@@ -0,0 +1,448 @@
+package apachelog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error log time layouts, as written by %t, %{u}t and %{cu}t. Fractional
+// seconds are accepted when parsing even if the layout does not mention them.
+const (
+	ErrorLogTimeFormat        = "Mon Jan 02 15:04:05 2006"
+	ErrorLogCompactTimeFormat = "2006-01-02 15:04:05"
+)
+
+// Error log formats used by default by Apache 2.2 and 2.4, expressed using the
+// ErrorLogFormat syntax.
+const (
+	ErrorLogFormat22 = "[%t] [%l] [client %a] %E: %M"
+	ErrorLogFormat24 = "[%{u}t] [%-m:%l] [pid %P:tid %T] %7F: %E: [client %a] %M"
+)
+
+// An ErrorLogEntry represents a line of an error log.
+type ErrorLogEntry struct {
+	Time       time.Time         // Time the message was logged
+	Level      string            // Log level of the message
+	Module     string            // Name of the module logging the message
+	ProcessID  int64             // Process ID of the current process
+	ThreadID   int64             // Thread ID of the current thread
+	Client     string            // Client IP address and port of the request
+	LocalAddr  string            // Local IP address and port
+	Status     string            // APR/OS error status code and string
+	SourceFile string            // Source file name and line number of the log call
+	LogID      string            // Log ID of the request or connection
+	ServerName string            // Canonical ServerName or server name of the request
+	KeepAlive  int64             // Number of keep-alive requests on the connection
+	Headers    map[string]string // Content of the request headers
+	Message    string            // Actual log message
+}
+
+// errorLogLevels lists the log levels known by Apache, including the trace
+// levels introduced in 2.4.
+var errorLogLevels = map[string]bool{
+	"emerg": true, "alert": true, "crit": true, "error": true, "warn": true,
+	"notice": true, "info": true, "debug": true, "trace1": true, "trace2": true,
+	"trace3": true, "trace4": true, "trace5": true, "trace6": true,
+	"trace7": true, "trace8": true,
+}
+
+// An ErrorLogParser for parsing Apache error log files.
+type ErrorLogParser struct {
+	br    *bufio.Reader
+	parse func(line string) (*ErrorLogEntry, error)
+}
+
+// NewErrorLogParser creates a new parser that reads from r and that parses the
+// error log entries written by Apache when no ErrorLogFormat directive is set.
+// Both the 2.2 and the 2.4 layouts are supported, for instance:
+//
+//	[Wed Oct 11 14:32:52 2000] [error] [client 127.0.0.1] client denied by server configuration: /export/home/live/ap/htdocs/test
+//	[Fri Sep 09 10:42:29.902022 2011] [core:error] [pid 35708:tid 4328636416] [client 72.15.99.187] File does not exist: /usr/local/apache2/htdocs/favicon.ico
+func NewErrorLogParser(r io.Reader) (*ErrorLogParser, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	return &ErrorLogParser{
+		br:    bufio.NewReader(r),
+		parse: parseDefaultErrorLine,
+	}, nil
+}
+
+// CustomErrorLogParser creates a new parser that reads from r and that is
+// capable of parsing error log entries having the given format, as defined by
+// the ErrorLogFormat Apache directive:
+//
+//	https://httpd.apache.org/docs/2.4/en/mod/core.html#errorlogformat
+//
+// Supported items are %t (including %{u}t and %{cu}t), %l, %m, %P, %T, %a, %A,
+// %E, %F, %L, %v, %V, %k, %{...}i and %M. As Apache omits the fields having an
+// empty item, the fields that do not match the line are skipped, unless one of
+// their items is flagged as required with the + modifier.
+func CustomErrorLogParser(r io.Reader, format string) (*ErrorLogParser, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	fields, err := lexErrorLogFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return &ErrorLogParser{
+		br: bufio.NewReader(r),
+		parse: func(line string) (*ErrorLogEntry, error) {
+			return parseErrorLine(fields, line)
+		},
+	}, nil
+}
+
+// Parse the next error log entry. If there is no more data to read and parse,
+// an io.EOF error is returned.
+func (p *ErrorLogParser) Parse() (*ErrorLogEntry, error) {
+	line, err := p.br.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	return p.parse(strings.TrimRight(line, "\r\n"))
+}
+
+// parseDefaultErrorLine parses a line written using the default 2.2 or 2.4
+// layout. Bracketed fields are recognized by their content, so that optional
+// fields can be missing.
+func parseDefaultErrorLine(line string) (*ErrorLogEntry, error) {
+	entry := ErrorLogEntry{Headers: make(map[string]string)}
+
+	if line == "" || line[0] != '[' {
+		return nil, errors.New("missing opening '['")
+	}
+	end := strings.IndexByte(line, ']')
+	if end == -1 {
+		return nil, errors.New("missing closing ']'")
+	}
+	t, err := parseErrorLogTime(line[1:end])
+	if err != nil {
+		return nil, err
+	}
+	entry.Time = t
+	line = strings.TrimPrefix(line[end+1:], " ")
+
+	for {
+		switch {
+		case strings.HasPrefix(line, "["):
+			end := strings.IndexByte(line, ']')
+			if end == -1 || !parseErrorLogBracket(&entry, line[1:end]) {
+				entry.Message = line
+				return &entry, nil
+			}
+			line = strings.TrimPrefix(line[end+1:], " ")
+		case entry.Status == "" && strings.HasPrefix(line, "("):
+			// APR/OS error status, such as "(2)No such file or directory: ".
+			end := strings.Index(line, ": ")
+			code := strings.IndexByte(line, ')')
+			if end == -1 || code == -1 || code > end {
+				entry.Message = line
+				return &entry, nil
+			}
+			if _, err := strconv.Atoi(line[1:code]); err != nil {
+				entry.Message = line
+				return &entry, nil
+			}
+			entry.Status = line[:end]
+			line = line[end+2:]
+		default:
+			entry.Message = line
+			return &entry, nil
+		}
+	}
+}
+
+// parseErrorLogBracket stores the content of a bracketed field of the default
+// layout into the entry. It reports whether the content has been recognized.
+func parseErrorLogBracket(entry *ErrorLogEntry, s string) bool {
+	switch {
+	case strings.HasPrefix(s, "pid "):
+		pid, tid, hasTid := strings.Cut(s[len("pid "):], ":tid ")
+		n, err := strconv.ParseInt(pid, 10, 64)
+		if err != nil {
+			return false
+		}
+		entry.ProcessID = n
+		if hasTid {
+			if entry.ThreadID, err = strconv.ParseInt(tid, 10, 64); err != nil {
+				return false
+			}
+		}
+	case strings.HasPrefix(s, "client "):
+		entry.Client = s[len("client "):]
+	case strings.HasPrefix(s, "remote "):
+		entry.Client = s[len("remote "):]
+	case errorLogLevels[s] && entry.Level == "":
+		entry.Level = s
+	default:
+		module, level, found := strings.Cut(s, ":")
+		if !found || !errorLogLevels[level] || entry.Level != "" {
+			return false
+		}
+		entry.Module = module
+		entry.Level = level
+	}
+	return true
+}
+
+// parseErrorLogTime parses a time written by %t, %{u}t or %{cu}t. Since error
+// logs do not include the timezone, the time is assumed to be local.
+func parseErrorLogTime(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(ErrorLogTimeFormat, s, time.Local)
+	if err != nil {
+		var err2 error
+		if t, err2 = time.ParseInLocation(ErrorLogCompactTimeFormat, s, time.Local); err2 == nil {
+			return t, nil
+		}
+		return time.Time{}, errors.New("failed to parse datetime: " + err.Error())
+	}
+	return t, nil
+}
+
+// An errorLogItem is either a literal text or an item, such as %l or
+// %{Referer}i, of an ErrorLogFormat string.
+type errorLogItem struct {
+	lit      string // literal text, for literal items
+	letter   byte   // letter of the item, 0 for literals
+	param    string // content of the curly braces, if any
+	required bool   // + modifier: the line is invalid if the item is missing
+	hyphen   bool   // - modifier: a "-" is logged if the item is empty
+}
+
+// An errorLogField is a group of items delimited by spaces or by "% ". Apache
+// omits the whole field if one of its items is empty.
+type errorLogField struct {
+	items []errorLogItem
+	space bool // the field is followed by a space
+}
+
+// lexErrorLogFormat splits an ErrorLogFormat string into fields.
+func lexErrorLogFormat(format string) ([]errorLogField, error) {
+	var fields []errorLogField
+	var cur errorLogField
+	var lit strings.Builder
+
+	flushLit := func() {
+		if lit.Len() > 0 {
+			cur.items = append(cur.items, errorLogItem{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	flushField := func(space bool) {
+		flushLit()
+		if len(cur.items) > 0 {
+			cur.space = space
+			fields = append(fields, cur)
+		}
+		cur = errorLogField{}
+	}
+
+	for i := 0; i < len(format); i++ {
+		switch c := format[i]; c {
+		case '\\':
+			if i+1 < len(format) {
+				i++
+				switch format[i] {
+				case 'n':
+					lit.WriteByte('\n')
+				case 't':
+					lit.WriteByte('\t')
+				default:
+					lit.WriteByte(format[i])
+				}
+				continue
+			}
+			lit.WriteByte(c)
+		case ' ':
+			flushField(true)
+		case '%':
+			if i+1 >= len(format) {
+				return nil, errors.New("format ends with an incomplete item")
+			}
+			switch format[i+1] {
+			case '%':
+				lit.WriteByte('%')
+				i++
+				continue
+			case ' ':
+				flushField(false)
+				i++
+				continue
+			}
+			var item errorLogItem
+			j := i + 1
+		loop:
+			for ; j < len(format); j++ {
+				switch c := format[j]; {
+				case c == '+':
+					item.required = true
+				case c == '-':
+					item.hyphen = true
+				case c >= '0' && c <= '9':
+					// minimum log level of the item, irrelevant when parsing
+				case c == '{':
+					end := strings.IndexByte(format[j:], '}')
+					if end == -1 {
+						return nil, fmt.Errorf("%q format is missing a closing '}'", format[i:])
+					}
+					item.param = format[j+1 : j+end]
+					j += end
+				default:
+					break loop
+				}
+			}
+			if j >= len(format) {
+				return nil, errors.New("format ends with an incomplete item")
+			}
+			item.letter = format[j]
+			if !strings.ContainsRune("tlmPTaAEFLvVkiM", rune(item.letter)) {
+				return nil, fmt.Errorf("%q format is not supported", format[i:j+1])
+			}
+			flushLit()
+			cur.items = append(cur.items, item)
+			i = j
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flushField(false)
+
+	return fields, nil
+}
+
+// parseErrorLine parses a line according to the given fields.
+func parseErrorLine(fields []errorLogField, line string) (*ErrorLogEntry, error) {
+	entry := ErrorLogEntry{Headers: make(map[string]string)}
+	pos := 0
+	for i, fld := range fields {
+		off, err := parseErrorLogField(&entry, fld, line[pos:], i == len(fields)-1, fields[i+1:])
+		if err != nil {
+			for _, item := range fld.items {
+				if item.required {
+					return nil, err
+				}
+			}
+			continue // the field has been omitted
+		}
+		pos += off
+		if fld.space && pos < len(line) && line[pos] == ' ' {
+			pos++
+		}
+	}
+	return &entry, nil
+}
+
+// parseErrorLogField parses the given field from the beginning of s and returns
+// the number of bytes consumed. The entry is only updated if the whole field
+// matches.
+func parseErrorLogField(entry *ErrorLogEntry, fld errorLogField, s string, last bool, rest []errorLogField) (int, error) {
+	tmp := *entry
+	tmp.Headers = make(map[string]string, len(entry.Headers))
+	for k, v := range entry.Headers {
+		tmp.Headers[k] = v
+	}
+
+	pos := 0
+	for i, item := range fld.items {
+		if item.letter == 0 {
+			if !strings.HasPrefix(s[pos:], item.lit) {
+				return 0, fmt.Errorf("got %q, want %q", s[pos:], item.lit)
+			}
+			pos += len(item.lit)
+			continue
+		}
+
+		// Determine where the value of the item ends: at the next literal of
+		// the field, at the end of the line for the last field, and at the next
+		// space otherwise.
+		input := s[pos:]
+		end := -1
+		switch {
+		case i+1 < len(fld.items) && fld.items[i+1].letter == 0:
+			end = strings.Index(input, fld.items[i+1].lit)
+		case last || item.letter == 'M':
+			end = len(input)
+			if !last && len(rest) > 0 && rest[0].items[0].letter == 0 {
+				if idx := strings.Index(input, rest[0].items[0].lit); idx != -1 {
+					end = idx
+				}
+			}
+			end = len(strings.TrimRight(input[:end], " "))
+		default:
+			if end = strings.IndexByte(input, ' '); end == -1 {
+				end = len(input)
+			}
+		}
+		if end <= 0 {
+			return 0, fmt.Errorf("missing value for %%%c item", item.letter)
+		}
+		if err := setErrorLogItem(&tmp, item, input[:end]); err != nil {
+			return 0, err
+		}
+		pos += end
+	}
+
+	*entry = tmp
+	return pos, nil
+}
+
+// setErrorLogItem stores the value of an item into the entry.
+func setErrorLogItem(entry *ErrorLogEntry, item errorLogItem, value string) error {
+	if item.hyphen && value == "-" {
+		return nil
+	}
+	if !strings.ContainsRune("tEiM", rune(item.letter)) && strings.ContainsRune(value, ' ') {
+		return fmt.Errorf("unexpected space in %%%c item value %q", item.letter, value)
+	}
+	var err error
+	switch item.letter {
+	case 't':
+		entry.Time, err = parseErrorLogTime(value)
+	case 'l':
+		if !errorLogLevels[value] {
+			return fmt.Errorf("unknown log level %q", value)
+		}
+		entry.Level = value
+	case 'm':
+		entry.Module = value
+	case 'P':
+		if entry.ProcessID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("malformed process id: " + err.Error())
+		}
+	case 'T':
+		if entry.ThreadID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("malformed thread id: " + err.Error())
+		}
+	case 'a':
+		entry.Client = value
+	case 'A':
+		entry.LocalAddr = value
+	case 'E':
+		if !strings.HasPrefix(value, "(") {
+			return fmt.Errorf("got %q, want '('", value[0])
+		}
+		entry.Status = value
+	case 'F':
+		entry.SourceFile = value
+	case 'L':
+		entry.LogID = value
+	case 'v', 'V':
+		entry.ServerName = value
+	case 'k':
+		if entry.KeepAlive, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("malformed keep-alive count: " + err.Error())
+		}
+	case 'i':
+		entry.Headers[item.param] = value
+	case 'M':
+		entry.Message = value
+	}
+	return err
+}
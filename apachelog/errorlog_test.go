@@ -0,0 +1,152 @@
+package apachelog
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errorLogLines = `[Wed Oct 11 14:32:52 2000] [error] [client 127.0.0.1] client denied by server configuration: /export/home/live/ap/htdocs/test
+[Wed Oct 11 14:32:53 2000] [error] [client 127.0.0.1] (13)Permission denied: access to /index.html denied
+[Fri Sep 09 10:42:29.902022 2011] [core:error] [pid 35708:tid 4328636416] [client 72.15.99.187:54321] AH00128: File does not exist: /usr/local/apache2/htdocs/favicon.ico
+[Fri Sep 09 10:42:30.000001 2011] [proxy:error] [pid 35708:tid 4328636416] (111)Connection refused: [client 72.15.99.187:54322] AH00957: HTTP: attempt to connect to 127.0.0.1:8080 failed
+[Fri Sep 09 10:42:31.000000 2011] [mpm_event:notice] [pid 1:tid 140] AH00489: Apache/2.4.57 (Unix) configured -- resuming normal operations
+`
+
+func TestNewErrorLogParser(t *testing.T) {
+	want := []ErrorLogEntry{
+		{
+			Time:    time.Date(2000, 10, 11, 14, 32, 52, 0, time.Local),
+			Level:   "error",
+			Client:  "127.0.0.1",
+			Message: "client denied by server configuration: /export/home/live/ap/htdocs/test",
+		},
+		{
+			Time:    time.Date(2000, 10, 11, 14, 32, 53, 0, time.Local),
+			Level:   "error",
+			Client:  "127.0.0.1",
+			Status:  "(13)Permission denied",
+			Message: "access to /index.html denied",
+		},
+		{
+			Time:      time.Date(2011, 9, 9, 10, 42, 29, 902022000, time.Local),
+			Module:    "core",
+			Level:     "error",
+			ProcessID: 35708,
+			ThreadID:  4328636416,
+			Client:    "72.15.99.187:54321",
+			Message:   "AH00128: File does not exist: /usr/local/apache2/htdocs/favicon.ico",
+		},
+		{
+			Time:      time.Date(2011, 9, 9, 10, 42, 30, 1000, time.Local),
+			Module:    "proxy",
+			Level:     "error",
+			ProcessID: 35708,
+			ThreadID:  4328636416,
+			Status:    "(111)Connection refused",
+			Client:    "72.15.99.187:54322",
+			Message:   "AH00957: HTTP: attempt to connect to 127.0.0.1:8080 failed",
+		},
+		{
+			Time:      time.Date(2011, 9, 9, 10, 42, 31, 0, time.Local),
+			Module:    "mpm_event",
+			Level:     "notice",
+			ProcessID: 1,
+			ThreadID:  140,
+			Message:   "AH00489: Apache/2.4.57 (Unix) configured -- resuming normal operations",
+		},
+	}
+
+	p, err := NewErrorLogParser(strings.NewReader(errorLogLines))
+	if err != nil {
+		t.Fatalf("NewErrorLogParser(...): unexpected error %q", err.Error())
+	}
+	for i, w := range want {
+		w.Headers = map[string]string{}
+		got, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%d. Parse(): unexpected error %q", i, err.Error())
+		}
+		if !reflect.DeepEqual(*got, w) {
+			t.Errorf("%d. Parse(): got %+v; want %+v", i, *got, w)
+		}
+	}
+	if _, err := p.Parse(); err != io.EOF {
+		t.Errorf("Parse(): got error %v; want io.EOF", err)
+	}
+}
+
+func TestCustomErrorLogParser(t *testing.T) {
+	type testCase struct {
+		format string
+		line   string
+		want   ErrorLogEntry
+	}
+
+	testCases := []testCase{
+		{
+			format: ErrorLogFormat24,
+			line:   "[Fri Sep 09 10:42:30.000001 2011] [proxy:error] [pid 35708:tid 4328636416] (111)Connection refused: [client 72.15.99.187:54322] AH00957: HTTP: attempt to connect to 127.0.0.1:8080 failed",
+			want: ErrorLogEntry{
+				Time:      time.Date(2011, 9, 9, 10, 42, 30, 1000, time.Local),
+				Module:    "proxy",
+				Level:     "error",
+				ProcessID: 35708,
+				ThreadID:  4328636416,
+				Status:    "(111)Connection refused",
+				Client:    "72.15.99.187:54322",
+				Message:   "AH00957: HTTP: attempt to connect to 127.0.0.1:8080 failed",
+			},
+		},
+		{
+			format: ErrorLogFormat24,
+			line:   "[Fri Sep 09 10:42:31.000000 2011] [-:notice] [pid 1:tid 140] mod_ssl.c(1234): AH00489: resuming normal operations",
+			want: ErrorLogEntry{
+				Time:       time.Date(2011, 9, 9, 10, 42, 31, 0, time.Local),
+				Level:      "notice",
+				ProcessID:  1,
+				ThreadID:   140,
+				SourceFile: "mod_ssl.c(1234)",
+				Message:    "AH00489: resuming normal operations",
+			},
+		},
+		{
+			format: `[%{cu}t] [%l] [client\ %a] %M% ,\ referer\ %{Referer}i`,
+			line:   "[2012-03-14 09:10:12.123456] [warn] [client 10.0.0.1:80] something happened, referer http://example.com/",
+			want: ErrorLogEntry{
+				Time:    time.Date(2012, 3, 14, 9, 10, 12, 123456000, time.Local),
+				Level:   "warn",
+				Client:  "10.0.0.1:80",
+				Message: "something happened",
+				Headers: map[string]string{"Referer": "http://example.com/"},
+			},
+		},
+	}
+
+	for i, test := range testCases {
+		p, err := CustomErrorLogParser(strings.NewReader(test.line), test.format)
+		if err != nil {
+			t.Fatalf("%d. CustomErrorLogParser(...): unexpected error %q", i, err.Error())
+		}
+		got, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%d. Parse(): unexpected error %q", i, err.Error())
+		}
+		if test.want.Headers == nil {
+			test.want.Headers = map[string]string{}
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%d. Parse(): got %+v; want %+v", i, *got, test.want)
+		}
+	}
+}
+
+func TestLexErrorLogFormat(t *testing.T) {
+	for _, format := range []string{"%t %", "%{Referer", "%x"} {
+		if _, err := lexErrorLogFormat(format); err == nil {
+			t.Errorf("lexErrorLogFormat(%q): expected error; got none", format)
+		}
+	}
+}
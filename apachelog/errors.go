@@ -0,0 +1,27 @@
+package apachelog
+
+import "fmt"
+
+// A ParseError is returned for malformed log entries. It locates the error in
+// the input and identifies the directive that failed to parse the value.
+type ParseError struct {
+	Line      int    // Line number, starting at 1
+	Column    int    // Column of the value in the line (in bytes), starting at 1
+	Offset    int64  // Offset of the line in the input (in bytes)
+	Directive string // Directive that failed, such as %>s. Empty for literal text
+	Format    Format // Format of the directive, UNKNOWN for literal text
+	Raw       string // Raw line, without trailing newline character
+	Err       error  // Underlying error
+}
+
+func (e *ParseError) Error() string {
+	if e.Directive == "" {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %v", e.Line, e.Column, e.Directive, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
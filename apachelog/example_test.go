@@ -31,3 +31,36 @@ func main() {
 		fmt.Println(logEntry)
 	}
 }
+
+func ExampleParser_Next() {
+	p, err := apachelog.CombinedParser(bytes.NewBufferString(accessLogs))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for p.Next() {
+		entry := p.Entry()
+		fmt.Println(entry.RemoteHost, entry.RequestFirstLine.Path(), entry.Status)
+	}
+	if err := p.Err(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// 127.0.0.1 /assets/img/logo.jpg 200
+}
+
+func ExampleParser_All() {
+	p, err := apachelog.CombinedParser(bytes.NewBufferString(accessLogs))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for entry, err := range p.All() {
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(entry.RemoteHost, entry.RequestFirstLine.Path(), entry.Status)
+	}
+	// Output:
+	// 127.0.0.1 /assets/img/logo.jpg 200
+}
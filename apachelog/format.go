@@ -1,6 +1,8 @@
 package apachelog
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -78,7 +80,7 @@ var formats = [...]string{
 
 func (f Format) String() string {
 	if f > format_beg && f < format_end {
-		return formats[f+1]
+		return formats[f]
 	}
 	return formats[UNKNOWN]
 }
@@ -92,15 +94,182 @@ func init() {
 	}
 }
 
-// LookupFormat retrieves the format corresponding to the given format string.
-func LookupFormat(format string) Format {
-	if strings.HasPrefix(format, "%{") {
-		if idx := strings.Index(format, "}"); idx != -1 {
-			format = "%{..." + format[idx:]
+// formatVariants lists the parameters accepted by the formats that are
+// otherwise used without any, such as %{c}a or %{remote}p.
+var formatVariants = map[Format][]string{
+	REMOTE_IP_ADDRESS:   {"c"},
+	REMOTE_HOST:         {"c"},
+	PORT:                {"canonical", "local", "remote"},
+	PROCESS_ID:          {"pid", "tid", "hextid"},
+	ELAPSED_TIME_IN_SEC: {"s", "ms", "us"},
+}
+
+// A Directive is a piece of a format string, as returned by ParseFormat:
+// either a directive, such as %>s or "%!200,304{Referer}i", broken down into
+// its components, or the literal text between directives.
+type Directive struct {
+	Format     Format // UNKNOWN for literal text
+	Literal    string // literal text, when Format is UNKNOWN
+	Param      string // content of the curly braces, if any
+	Conditions []int  // status codes for which the value is logged
+	Negate     bool   // the value is logged only if the status does not match
+	Original   bool   // the < modifier is used
+	Final      bool   // the > modifier is used
+	Quoted     bool   // the directive is enclosed in double quotes
+}
+
+// ParseFormat breaks down the given format string into directives and literal
+// texts, in order. An error is returned if the format contains a directive
+// that is not supported. See CustomParser for details about the supported
+// formats.
+//
+// The format string can be regenerated from the directives using
+// FormatString, once modified for instance.
+func ParseFormat(format string) ([]Directive, error) {
+	tokens, err := lexFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	directives := make([]Directive, 0, len(tokens))
+	for _, tok := range tokens {
+		if tok.typ == tokenLiteral {
+			directives = append(directives, Directive{Format: UNKNOWN, Literal: tok.val})
+			continue
+		}
+		d := parseDirective(tok.val)
+		if makeDirectiveFn(d, field{}, nil) == nil {
+			return nil, fmt.Errorf("%q format is not supported", tok.val)
+		}
+		d.Quoted = tok.quoted
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+// FormatString returns the format string made of the given directives. It is
+// the reverse of ParseFormat.
+func FormatString(directives []Directive) string {
+	var b strings.Builder
+	for _, d := range directives {
+		b.WriteString(d.String())
+	}
+	return b.String()
+}
+
+// String returns the directive as written in a format string, such as
+// "%!200,304{Referer}i", or the literal text, escaped if need be.
+func (d Directive) String() string {
+	if d.Format <= format_beg || d.Format >= format_end {
+		r := strings.NewReplacer("%", "%%", "\n", `\n`, "\t", `\t`)
+		return r.Replace(d.Literal)
+	}
+	var b strings.Builder
+	if d.Quoted {
+		b.WriteByte('"')
+	}
+	b.WriteByte('%')
+	if d.Negate {
+		b.WriteByte('!')
+	}
+	for i, code := range d.Conditions {
+		if i > 0 {
+			b.WriteByte(',')
 		}
+		b.WriteString(strconv.Itoa(code))
+	}
+	if d.Original {
+		b.WriteByte('<')
 	}
-	if f, found := formatsMapping[format]; found {
-		return f
+	if d.Final {
+		b.WriteByte('>')
 	}
-	return UNKNOWN
+	if d.Param != "" {
+		b.WriteString("{" + d.Param + "}")
+	}
+	name := formats[d.Format]
+	b.WriteByte(name[len(name)-1])
+	if d.Quoted {
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// parseDirective breaks down the given format string according to the
+// modifiers grammar defined by the mod_log_config Apache module:
+//    https://httpd.apache.org/docs/2.4/en/mod/mod_log_config.html#modifiers
+//
+// The returned directive has its format set to UNKNOWN if the format string is
+// malformed or not supported.
+func parseDirective(s string) (d Directive) {
+	d.Format = UNKNOWN
+	if len(s) < 2 || s[0] != '%' {
+		return
+	}
+	var hasParam bool
+	i := 1
+	for ; i < len(s)-1; i++ {
+		switch c := s[i]; {
+		case c == '!':
+			d.Negate = true
+		case c == '<':
+			d.Original, d.Final = true, false
+		case c == '>':
+			d.Original, d.Final = false, true
+		case c == ',':
+			// separator between status codes
+		case c >= '0' && c <= '9':
+			code := 0
+			for ; i < len(s)-1 && s[i] >= '0' && s[i] <= '9'; i++ {
+				code = code*10 + int(s[i]-'0')
+			}
+			i-- // compensate the loop increment
+			d.Conditions = append(d.Conditions, code)
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return
+			}
+			d.Param = s[i+1 : i+end]
+			hasParam = true
+			i += end
+		default:
+			return
+		}
+	}
+	if i != len(s)-1 {
+		return
+	}
+	letter := s[i:]
+	if hasParam {
+		if letter == "t" {
+			// The parameter of %{...}t is a time format, checked when the
+			// directive is compiled.
+			d.Format = TIME
+			return
+		}
+		if f, found := formatsMapping["%{...}"+letter]; found {
+			d.Format = f
+			return
+		}
+		if f, found := formatsMapping["%"+letter]; found {
+			for _, v := range formatVariants[f] {
+				if v == d.Param {
+					d.Format = f
+					return
+				}
+			}
+		}
+		return
+	}
+	if f, found := formatsMapping["%"+letter]; found {
+		d.Format = f
+	}
+	return
+}
+
+// LookupFormat retrieves the format corresponding to the given format string.
+//
+// The format string may include modifiers, such as %>s or %400,501{Referer}i.
+func LookupFormat(format string) Format {
+	return parseDirective(format).Format
 }
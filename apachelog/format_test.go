@@ -0,0 +1,143 @@
+package apachelog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookupFormat(t *testing.T) {
+	type testCase struct {
+		in   string
+		want Format
+	}
+
+	testCases := []testCase{
+		{in: "%h", want: REMOTE_HOST},
+		{in: "%>s", want: STATUS},
+		{in: "%<s", want: STATUS},
+		{in: "%{Referer}i", want: HEADER},
+		{in: "%400,501{User-agent}i", want: HEADER},
+		{in: "%!200,304,302{Referer}i", want: HEADER},
+		{in: "%!200U", want: URL_PATH},
+		{in: "%{c}a", want: REMOTE_IP_ADDRESS},
+		{in: "%{remote}p", want: PORT},
+		{in: "%{hextid}P", want: PROCESS_ID},
+		{in: "%{ms}T", want: ELAPSED_TIME_IN_SEC},
+		{in: "%{%d/%b/%Y %T}t", want: TIME},
+		{in: "%{begin:msec}t", want: TIME},
+		{in: "%{foo}a", want: UNKNOWN},
+		{in: "%{Referer", want: UNKNOWN},
+		{in: "%x", want: UNKNOWN},
+		{in: "%hh", want: UNKNOWN},
+		{in: "%", want: UNKNOWN},
+		{in: "foo", want: UNKNOWN},
+	}
+
+	for i, test := range testCases {
+		if got := LookupFormat(test.in); got != test.want {
+			t.Errorf("%d. LookupFormat(%q): got %d; want %d", i, test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseDirective(t *testing.T) {
+	type testCase struct {
+		in   string
+		want Directive
+	}
+
+	testCases := []testCase{
+		{
+			in:   "%>s",
+			want: Directive{Format: STATUS, Final: true},
+		},
+		{
+			in:   "%<s",
+			want: Directive{Format: STATUS, Original: true},
+		},
+		{
+			in:   "%400,501{User-agent}i",
+			want: Directive{Format: HEADER, Param: "User-agent", Conditions: []int{400, 501}},
+		},
+		{
+			in:   "%!200,304{Referer}i",
+			want: Directive{Format: HEADER, Param: "Referer", Conditions: []int{200, 304}, Negate: true},
+		},
+	}
+
+	for i, test := range testCases {
+		if got := parseDirective(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. parseDirective(%q): got %+v; want %+v", i, test.in, got, test.want)
+		}
+	}
+}
+
+func TestFormat_String(t *testing.T) {
+	type testCase struct {
+		in   Format
+		want string
+	}
+
+	testCases := []testCase{
+		{in: REMOTE_IP_ADDRESS, want: "%a"},
+		{in: HEADER, want: "%{...}i"},
+		{in: ELAPSED_TIME_IN_SEC, want: "%T"},
+		{in: format_end, want: "UNKNOWN"},
+	}
+
+	for i, test := range testCases {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("%d. Format(%d).String(): got %q; want %q", i, test.in, got, test.want)
+		}
+	}
+
+	// Every format must be mapped back to itself.
+	for f := format_beg + 1; f < format_end; f++ {
+		if got := formatsMapping[f.String()]; got != f {
+			t.Errorf("Format(%d).String(): got %q, mapped to %d", f, f.String(), got)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	format := `%h [%{%d/%b/%Y %T}t] "%!200,304<{Referer}i" 100%% %>s\t%{c}a`
+	want := []Directive{
+		{Format: REMOTE_HOST},
+		{Format: UNKNOWN, Literal: " ["},
+		{Format: TIME, Param: "%d/%b/%Y %T"},
+		{Format: UNKNOWN, Literal: "] "},
+		{Format: HEADER, Param: "Referer", Conditions: []int{200, 304}, Negate: true, Original: true, Quoted: true},
+		{Format: UNKNOWN, Literal: " 100% "},
+		{Format: STATUS, Final: true},
+		{Format: UNKNOWN, Literal: "\t"},
+		{Format: REMOTE_IP_ADDRESS, Param: "c"},
+	}
+	got, err := ParseFormat(format)
+	if err != nil {
+		t.Fatalf("ParseFormat(%q): unexpected error %q", format, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseFormat(%q): got %+v; want %+v", format, got, want)
+	}
+	if got, want := FormatString(got), `%h [%{%d/%b/%Y %T}t] "%!200,304<{Referer}i" 100%% %>s\t%{c}a`; got != want {
+		t.Errorf("FormatString(): got %q; want %q", got, want)
+	}
+
+	for _, name := range FormatNames() {
+		format, _ := NamedFormat(name)
+		directives, err := ParseFormat(format)
+		if err != nil {
+			t.Errorf("ParseFormat(%q): unexpected error %q", format, err.Error())
+			continue
+		}
+		if got := FormatString(directives); got != format {
+			t.Errorf("FormatString(ParseFormat(%q)): got %q", format, got)
+		}
+	}
+
+	for _, format := range []string{"%x", "%{foo}a", "%{%Q}t", "%{Referer"} {
+		if _, err := ParseFormat(format); err == nil {
+			t.Errorf("ParseFormat(%q): expected error; got none", format)
+		}
+	}
+}
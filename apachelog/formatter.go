@@ -0,0 +1,308 @@
+package apachelog
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// An appendFn appends the representation of a piece of an entry to dst and
+// returns the extended buffer.
+type appendFn func(dst []byte, entry *AccessLogEntry) []byte
+
+// A Formatter renders access log entries as log lines, following a format
+// string as defined by the mod_log_config Apache module. It is the
+// counterpart of the Parser, and both accept the same format strings.
+type Formatter struct {
+	fns []appendFn
+}
+
+// CombinedFormatter creates a new formatter rendering log entries using the
+// Apache Combined Log format.
+func CombinedFormatter() *Formatter {
+	f, _ := NewFormatter(CombinedLogFormat)
+	return f
+}
+
+// CommonFormatter creates a new formatter rendering log entries using the
+// Apache Common Log format.
+func CommonFormatter() *Formatter {
+	f, _ := NewFormatter(CommonLogFormat)
+	return f
+}
+
+// NewFormatter creates a new formatter rendering log entries using the given
+// format. See CustomParser for details about the supported formats.
+//
+// As Apache does, missing values are rendered as "-" and quoted values have
+// their double quotes, backslashes and control characters escaped.
+func NewFormatter(format string) (*Formatter, error) {
+	tokens, err := lexFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	fns := make([]appendFn, 0, len(tokens))
+	for _, tok := range tokens {
+		if tok.typ == tokenLiteral {
+			fns = append(fns, appendLiteral(tok.val))
+			continue
+		}
+		d := parseDirective(tok.val)
+		fn := makeAppendFn(d)
+		if fn == nil {
+			return nil, fmt.Errorf("%q format is not supported", tok.val)
+		}
+		if len(d.Conditions) > 0 {
+			fn = appendConditional(d, fn)
+		}
+		if tok.quoted {
+			fn = appendQuoted(fn)
+		}
+		fns = append(fns, fn)
+	}
+	return &Formatter{fns: fns}, nil
+}
+
+// AppendFormat appends the log line representing the entry to dst and returns
+// the extended buffer. The line is not terminated by a newline character.
+func (f *Formatter) AppendFormat(dst []byte, entry *AccessLogEntry) []byte {
+	for _, fn := range f.fns {
+		dst = fn(dst, entry)
+	}
+	return dst
+}
+
+// Format returns the log line representing the entry, without trailing
+// newline character.
+func (f *Formatter) Format(entry *AccessLogEntry) string {
+	return string(f.AppendFormat(nil, entry))
+}
+
+// Write writes the log line representing the entry to w, followed by a
+// newline character.
+func (f *Formatter) Write(w io.Writer, entry *AccessLogEntry) error {
+	buf := f.AppendFormat(nil, entry)
+	buf = append(buf, '\n')
+	_, err := w.Write(buf)
+	return err
+}
+
+// makeAppendFn returns the function rendering the information described by the
+// given directive, or nil if the directive is not supported.
+func makeAppendFn(d Directive) appendFn {
+	switch d.Format {
+	case REMOTE_IP_ADDRESS:
+		if d.Param == "c" {
+			return appendString(func(e *AccessLogEntry) string { return e.PeerIPAddr })
+		}
+		return appendString(func(e *AccessLogEntry) string { return e.RemoteIPAddr })
+	case LOCAL_IP_ADDRESS:
+		return appendString(func(e *AccessLogEntry) string { return e.LocalIPAddr })
+	case RESPONSE_SIZE:
+		return appendInt(func(e *AccessLogEntry) int64 { return e.ResponseSize })
+	case RESPONSE_SIZE_CLF:
+		return func(dst []byte, e *AccessLogEntry) []byte {
+			if e.ResponseSize == 0 {
+				return append(dst, '-')
+			}
+			return strconv.AppendInt(dst, e.ResponseSize, 10)
+		}
+	case COOKIE:
+		return appendString(func(e *AccessLogEntry) string { return e.Cookies[d.Param] })
+	case ELAPSED_TIME:
+		return appendInt(func(e *AccessLogEntry) int64 { return e.ElapsedTime })
+	case ENV_VAR:
+		return appendString(func(e *AccessLogEntry) string { return e.EnvVars[d.Param] })
+	case HEADER:
+		return appendString(func(e *AccessLogEntry) string { return e.Headers[d.Param] })
+	case FILENAME:
+		return appendString(func(e *AccessLogEntry) string { return e.Filename })
+	case REMOTE_HOST:
+		return appendString(func(e *AccessLogEntry) string { return e.RemoteHost })
+	case REQUEST_PROTO:
+		return appendString(func(e *AccessLogEntry) string { return e.RequestProto })
+	case REMOTE_LOGNAME:
+		return appendString(func(e *AccessLogEntry) string { return e.RemoteLogname })
+	case REQUEST_METHOD:
+		return appendString(func(e *AccessLogEntry) string { return e.RequestMethod })
+	case PORT:
+		switch d.Param {
+		case "local":
+			return appendString(func(e *AccessLogEntry) string { return e.LocalPort })
+		case "remote":
+			return appendString(func(e *AccessLogEntry) string { return e.RemotePort })
+		}
+		return appendString(func(e *AccessLogEntry) string { return e.Port })
+	case PROCESS_ID:
+		switch d.Param {
+		case "tid":
+			return appendInt(func(e *AccessLogEntry) int64 { return e.ThreadID })
+		case "hextid":
+			return func(dst []byte, e *AccessLogEntry) []byte {
+				return strconv.AppendInt(dst, e.ThreadID, 16)
+			}
+		}
+		return appendInt(func(e *AccessLogEntry) int64 { return e.ProcessID })
+	case QUERY_STRING:
+		// Apache logs an empty string, not a "-", when there is no query.
+		return func(dst []byte, e *AccessLogEntry) []byte {
+			return appendEscaped(dst, e.QueryString)
+		}
+	case REQUEST_FIRST_LINE:
+		return appendString(func(e *AccessLogEntry) string { return e.RequestFirstLine.String() })
+	case STATUS:
+		return appendString(func(e *AccessLogEntry) string {
+			if e.Status == "" && e.StatusCode.IsSet() {
+				return e.StatusCode.String()
+			}
+			return e.Status
+		})
+	case TIME:
+		if d.Param != "" {
+			tf, err := parseTimeFormat(d.Param)
+			if err != nil {
+				return nil
+			}
+			return appendTime(tf)
+		}
+		return func(dst []byte, e *AccessLogEntry) []byte {
+			dst = append(dst, '[')
+			dst = e.Time.AppendFormat(dst, StandardEnglishFormat)
+			return append(dst, ']')
+		}
+	case REMOTE_USER:
+		return appendString(func(e *AccessLogEntry) string { return e.RemoteUser })
+	case URL_PATH:
+		return appendString(func(e *AccessLogEntry) string { return e.URLPath })
+	case CANONICAL_SERVER_NAME:
+		return appendString(func(e *AccessLogEntry) string { return e.CanonicalServerName })
+	case SERVER_NAME:
+		return appendString(func(e *AccessLogEntry) string { return e.ServerName })
+	case BYTES_RECEIVED:
+		return appendInt(func(e *AccessLogEntry) int64 { return e.BytesReceived })
+	case BYTES_SENT:
+		return appendInt(func(e *AccessLogEntry) int64 { return e.BytesSent })
+	case ELAPSED_TIME_IN_SEC:
+		switch d.Param {
+		case "ms":
+			return appendInt(func(e *AccessLogEntry) int64 { return e.ElapsedTime / 1000 })
+		case "us":
+			return appendInt(func(e *AccessLogEntry) int64 { return e.ElapsedTime })
+		}
+		return appendInt(func(e *AccessLogEntry) int64 { return e.ElapsedTimeSec })
+	}
+	return nil
+}
+
+// appendTime renders the time of an entry as a %{...}t directive does.
+func appendTime(tf timeFormat) appendFn {
+	return func(dst []byte, e *AccessLogEntry) []byte {
+		switch tf.kind {
+		case timeSec:
+			return strconv.AppendInt(dst, e.Time.Unix(), 10)
+		case timeMsec:
+			return strconv.AppendInt(dst, e.Time.UnixMilli(), 10)
+		case timeUsec:
+			return strconv.AppendInt(dst, e.Time.UnixMicro(), 10)
+		case timeMsecFrac:
+			return appendPadded(dst, int64(e.Time.Nanosecond()/1e6), 3)
+		case timeUsecFrac:
+			return appendPadded(dst, int64(e.Time.Nanosecond()/1e3), 6)
+		}
+		return e.Time.AppendFormat(dst, tf.layout)
+	}
+}
+
+// appendPadded appends n, padded with zeros up to the given width.
+func appendPadded(dst []byte, n int64, width int) []byte {
+	start := len(dst)
+	dst = strconv.AppendInt(dst, n, 10)
+	for len(dst)-start < width {
+		dst = append(dst, '0')
+		copy(dst[start+1:], dst[start:])
+		dst[start] = '0'
+	}
+	return dst
+}
+
+func appendLiteral(lit string) appendFn {
+	return func(dst []byte, _ *AccessLogEntry) []byte {
+		return append(dst, lit...)
+	}
+}
+
+// appendString renders the string returned by get, escaped, or a "-" if the
+// string is empty.
+func appendString(get func(*AccessLogEntry) string) appendFn {
+	return func(dst []byte, e *AccessLogEntry) []byte {
+		s := get(e)
+		if s == "" {
+			return append(dst, '-')
+		}
+		return appendEscaped(dst, s)
+	}
+}
+
+func appendInt(get func(*AccessLogEntry) int64) appendFn {
+	return func(dst []byte, e *AccessLogEntry) []byte {
+		return strconv.AppendInt(dst, get(e), 10)
+	}
+}
+
+func appendQuoted(fn appendFn) appendFn {
+	return func(dst []byte, e *AccessLogEntry) []byte {
+		dst = append(dst, '"')
+		dst = fn(dst, e)
+		return append(dst, '"')
+	}
+}
+
+// appendConditional renders a "-" instead of the value when the status of the
+// entry does not meet the conditions of the directive.
+func appendConditional(d Directive, fn appendFn) appendFn {
+	return func(dst []byte, e *AccessLogEntry) []byte {
+		status := int(e.StatusCode)
+		if !e.StatusCode.IsSet() {
+			status, _ = strconv.Atoi(e.Status)
+		}
+		var match bool
+		for _, code := range d.Conditions {
+			if code == status {
+				match = true
+				break
+			}
+		}
+		if match == d.Negate {
+			return append(dst, '-')
+		}
+		return fn(dst, e)
+	}
+}
+
+// appendEscaped appends s to dst, escaping the characters the same way Apache
+// does: double quotes and backslashes are prefixed with a backslash and
+// control characters are written as C-style or \xhh escape sequences.
+func appendEscaped(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c == '\b':
+			dst = append(dst, '\\', 'b')
+		case c == '\n':
+			dst = append(dst, '\\', 'n')
+		case c == '\r':
+			dst = append(dst, '\\', 'r')
+		case c == '\t':
+			dst = append(dst, '\\', 't')
+		case c == '\v':
+			dst = append(dst, '\\', 'v')
+		case c < 0x20 || c >= 0x7f:
+			dst = append(dst, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
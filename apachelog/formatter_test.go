@@ -0,0 +1,94 @@
+package apachelog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatter_roundTrip(t *testing.T) {
+	type testCase struct {
+		format string
+		line   string
+	}
+
+	testCases := []testCase{
+		{
+			format: CommonLogFormat,
+			line:   `127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /assets/img/logo.jpg HTTP/1.1" 200 -`,
+		},
+		{
+			format: CombinedLogFormat,
+			line:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`,
+		},
+		{
+			format: CombinedLogFormat,
+			line:   `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\"b HTTP/1.0" 200 2326 "-" "Mozilla \"compatible\" \\o/\x7f\xc3\xa9"`,
+		},
+		{
+			format: `%v:%p %a [%{X-Id}i] %D\t%I %O %{hextid}P`,
+			line:   "www.example.com:443 10.0.0.1 [abc 123] 5012\t420 8123 7f3a",
+		},
+		{
+			format: `%a %{c}a %p %{canonical}p %{local}p %{remote}p`,
+			line:   "192.0.2.1 10.0.0.5 80 80 8080 51234",
+		},
+		{
+			format: `%h [%{%d/%b/%Y %T}t.%{msec_frac}t %{%z}t] "%r"`,
+			line:   `127.0.0.1 [10/Oct/2000 13:55:36.042 -0700] "GET / HTTP/1.0"`,
+		},
+		{
+			format: `%h %{usec}t %{%FT%T}t.%{usec_frac}t "%r"`,
+			line:   `127.0.0.1 971211336000042 2000-10-10T20:55:36.000042 "GET / HTTP/1.0"`,
+		},
+	}
+
+	for i, test := range testCases {
+		p, err := CustomParser(strings.NewReader(test.line+"\n"), test.format)
+		if err != nil {
+			t.Fatalf("%d. CustomParser(...): unexpected error %q", i, err.Error())
+		}
+		entry, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%d. Parse(): unexpected error %q", i, err.Error())
+		}
+		f, err := NewFormatter(test.format)
+		if err != nil {
+			t.Fatalf("%d. NewFormatter(%q): unexpected error %q", i, test.format, err.Error())
+		}
+		if got := f.Format(entry); got != test.line {
+			t.Errorf("%d. NewFormatter(%q).Format(...): got %q; want %q", i, test.format, got, test.line)
+		}
+	}
+}
+
+func TestFormatter_Write(t *testing.T) {
+	entry := AccessLogEntry{
+		RemoteHost:       "127.0.0.1",
+		Time:             time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RequestFirstLine: NewRequestFirstLine("GET / HTTP/1.1"),
+		Status:           "404",
+		Headers:          map[string]string{"User-agent": "say \"hi\"\\\n"},
+	}
+	format := `%h %l %t "%r" %s %b "%{Referer}i" "%{User-agent}i" "%!404{User-agent}i"`
+	want := `127.0.0.1 - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 404 - "-" "say \"hi\"\\\n" "-"` + "\n"
+
+	f, err := NewFormatter(format)
+	if err != nil {
+		t.Fatalf("NewFormatter(%q): unexpected error %q", format, err.Error())
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("NewFormatter(%q).Write(...): got %q; want %q", format, got, want)
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	if _, err := NewFormatter("%h %x"); err == nil {
+		t.Errorf("NewFormatter(%q): expected error; got none", "%h %x")
+	}
+}
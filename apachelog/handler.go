@@ -0,0 +1,248 @@
+package apachelog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A handler is an http.Handler writing an access log entry for each request
+// served by the wrapped handler.
+type handler struct {
+	h       http.Handler
+	f       *Formatter
+	headers []string // request headers referenced by the format
+	cookies []string // cookies referenced by the format
+
+	mu sync.Mutex // serializes writes to w
+	w  io.Writer
+}
+
+// Handler returns an http.Handler that serves requests using h and that writes
+// an access log entry to w for each of them, using the given format. See
+// CustomParser for details about the supported formats.
+//
+// Write errors are ignored so that logging never prevents requests from being
+// served. The environment variables (%{...}e) and the filename (%f) are not
+// available to Go handlers, and are therefore logged as "-".
+func Handler(h http.Handler, w io.Writer, format string) (http.Handler, error) {
+	f, err := NewFormatter(format)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := lexFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	lh := &handler{h: h, f: f, w: w}
+	for _, tok := range tokens {
+		if tok.typ != tokenDirective {
+			continue
+		}
+		switch d := parseDirective(tok.val); d.Format {
+		case HEADER:
+			lh.headers = append(lh.headers, d.Param)
+		case COOKIE:
+			lh.cookies = append(lh.cookies, d.Param)
+		}
+	}
+	return lh, nil
+}
+
+func (lh *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	body := &countingReader{r: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	rw := &responseWriter{w: w, status: http.StatusOK}
+
+	lh.h.ServeHTTP(rw, r)
+	if !rw.wroteHeader {
+		// The response headers are sent by net/http once the handler returns.
+		rw.headerSize = responseHeaderSize(rw.status, w.Header())
+	}
+
+	elapsed := time.Since(start)
+	entry := lh.newEntry(r, start)
+	entry.Status = strconv.Itoa(rw.status)
+	entry.StatusCode = StatusCode(rw.status)
+	entry.ResponseSize = rw.size
+	entry.BytesSent = rw.headerSize + rw.size
+	entry.BytesReceived = requestHeaderSize(r) + body.n
+	entry.ElapsedTime = int64(elapsed / time.Microsecond)
+	entry.ElapsedTimeSec = int64(elapsed / time.Second)
+
+	lh.mu.Lock()
+	_ = lh.f.Write(lh.w, entry)
+	lh.mu.Unlock()
+}
+
+// newEntry creates an entry holding the information about the request.
+func (lh *handler) newEntry(r *http.Request, start time.Time) *AccessLogEntry {
+	entry := &AccessLogEntry{
+		Cookies:       make(map[string]string),
+		Headers:       make(map[string]string),
+		EnvVars:       make(map[string]string),
+		RequestMethod: r.Method,
+		RequestProto:  r.Proto,
+		ProcessID:     int64(os.Getpid()),
+		Time:          start,
+		URLPath:       r.URL.Path,
+		RequestFirstLine: NewRequestFirstLine(
+			r.Method + " " + r.RequestURI + " " + r.Proto),
+	}
+	if r.URL.RawQuery != "" {
+		entry.QueryString = "?" + r.URL.RawQuery
+	}
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.RemoteIPAddr = host
+		entry.RemoteHost = host
+		entry.RemotePort = port
+	} else {
+		entry.RemoteIPAddr = r.RemoteAddr
+		entry.RemoteHost = r.RemoteAddr
+	}
+	// Without mod_remoteip, the client is the peer of the connection.
+	entry.PeerIPAddr = entry.RemoteIPAddr
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			entry.LocalIPAddr = host
+			entry.Port = port
+			entry.LocalPort = port
+		}
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		entry.RemoteUser = user
+	}
+	serverName := r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		serverName = host
+	}
+	entry.CanonicalServerName = serverName
+	entry.ServerName = serverName
+	for _, name := range lh.headers {
+		if values := r.Header[textproto.CanonicalMIMEHeaderKey(name)]; len(values) > 0 {
+			entry.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+	for _, name := range lh.cookies {
+		if c, err := r.Cookie(name); err == nil {
+			entry.Cookies[name] = c.Value
+		}
+	}
+	return entry
+}
+
+// requestHeaderSize returns the size of the request line and headers, as sent
+// over the wire.
+func requestHeaderSize(r *http.Request) int64 {
+	n := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4 // 2 spaces + CRLF
+	for name, values := range r.Header {
+		for _, v := range values {
+			n += len(name) + len(v) + 4 // ": " + CRLF
+		}
+	}
+	if r.Host != "" && r.Header.Get("Host") == "" {
+		n += len("Host") + len(r.Host) + 4
+	}
+	return int64(n + 2) // final CRLF
+}
+
+// responseHeaderSize returns the size of the status line and headers of a
+// response, as sent over the wire.
+func responseHeaderSize(status int, header http.Header) int64 {
+	n := len("HTTP/1.1 000 ") + len(http.StatusText(status)) + 2 // CRLF
+	for name, values := range header {
+		for _, v := range values {
+			n += len(name) + len(v) + 4 // ": " + CRLF
+		}
+	}
+	return int64(n + 2) // final CRLF
+}
+
+// A countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) Close() error {
+	return cr.r.Close()
+}
+
+// A responseWriter records the status and the size of a response.
+type responseWriter struct {
+	w           http.ResponseWriter
+	status      int
+	size        int64
+	headerSize  int64
+	wroteHeader bool
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = status
+	rw.headerSize = responseHeaderSize(status, rw.w.Header())
+	rw.w.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.w.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// http.ResponseWriter supports it.
+func (rw *responseWriter) Flush() {
+	f, ok := rw.w.(http.Flusher)
+	if !ok {
+		return
+	}
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	f.Flush()
+}
+
+// Hijack lets the caller take over the connection, if the underlying
+// http.ResponseWriter supports it.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that
+// http.ResponseController can access its optional features such as flushing.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.w
+}
@@ -0,0 +1,114 @@
+package apachelog
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found")
+	})
+
+	format := `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i" %{session}C %D %I %O %{c}a %{remote}p`
+	var buf bytes.Buffer
+	lh, err := Handler(h, &buf, format)
+	if err != nil {
+		t.Fatalf("Handler(...): unexpected error %q", err.Error())
+	}
+
+	r := httptest.NewRequest("POST", "/search?q=foo", strings.NewReader("body"))
+	r.RemoteAddr = "192.168.1.10:51234"
+	r.Header.Set("User-Agent", "test-agent")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abcdef"})
+	r.SetBasicAuth("frank", "secret")
+	lh.ServeHTTP(httptest.NewRecorder(), r)
+
+	p, err := CustomParser(&buf, format)
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse(): unexpected error %q; line: %q", err.Error(), buf.String())
+	}
+	if got, want := entry.RemoteHost, "192.168.1.10"; got != want {
+		t.Errorf("got RemoteHost %q; want %q", got, want)
+	}
+	if got, want := entry.RemoteUser, "frank"; got != want {
+		t.Errorf("got RemoteUser %q; want %q", got, want)
+	}
+	if got, want := entry.RequestFirstLine.String(), "POST /search?q=foo HTTP/1.1"; got != want {
+		t.Errorf("got RequestFirstLine %q; want %q", got, want)
+	}
+	if got, want := entry.Status, "404"; got != want {
+		t.Errorf("got Status %q; want %q", got, want)
+	}
+	if got, want := entry.ResponseSize, int64(len("not found")); got != want {
+		t.Errorf("got ResponseSize %d; want %d", got, want)
+	}
+	if got, want := entry.Headers["Referer"], "-"; got != want {
+		t.Errorf("got Referer %q; want %q", got, want)
+	}
+	if got, want := entry.Headers["User-agent"], "test-agent"; got != want {
+		t.Errorf("got User-agent %q; want %q", got, want)
+	}
+	if got, want := entry.Cookies["session"], "abcdef"; got != want {
+		t.Errorf("got session cookie %q; want %q", got, want)
+	}
+	if got, want := entry.PeerIPAddr, "192.168.1.10"; got != want {
+		t.Errorf("got PeerIPAddr %q; want %q", got, want)
+	}
+	if got, want := entry.RemotePort, "51234"; got != want {
+		t.Errorf("got RemotePort %q; want %q", got, want)
+	}
+	if entry.BytesReceived <= int64(len("body")) {
+		t.Errorf("got BytesReceived %d; want more than the body size", entry.BytesReceived)
+	}
+	if entry.BytesSent <= entry.ResponseSize {
+		t.Errorf("got BytesSent %d; want more than the response size", entry.BytesSent)
+	}
+}
+
+func TestHandler_flush(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("ResponseWriter does not implement http.Flusher")
+		}
+		io.WriteString(w, "partial")
+		f.Flush()
+	})
+	lh, err := Handler(h, io.Discard, CommonLogFormat)
+	if err != nil {
+		t.Fatalf("Handler(...): unexpected error %q", err.Error())
+	}
+	rec := httptest.NewRecorder()
+	lh.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Error("Flush(): got no flush of the underlying ResponseWriter")
+	}
+}
+
+func TestHandler_hijackUnsupported(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("ResponseWriter does not implement http.Hijacker")
+		}
+		if _, _, err := hj.Hijack(); err == nil {
+			t.Error("Hijack(): expected error; got none")
+		}
+	})
+	lh, err := Handler(h, io.Discard, CommonLogFormat)
+	if err != nil {
+		t.Fatalf("Handler(...): unexpected error %q", err.Error())
+	}
+	lh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,191 @@
+package apachelog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Layout is a compiled access log format. It is safe for concurrent use, so
+// a single layout can be shared by many parsers and goroutines. The OnSkip
+// function and the DeadLetter writer of the layout are never called
+// concurrently.
+type Layout struct {
+	format string
+	fn     stateFn
+	cfg    config
+	naive  bool // the time is logged without timezone offset
+
+	skipMu sync.Mutex // serializes the OnSkip and DeadLetter calls
+}
+
+// Compile compiles the given format, so that it can be used to parse many
+// inputs without being compiled again. See CustomParser for details about the
+// supported formats.
+//
+// The options apply to every parser created from the layout.
+func Compile(format string, opts ...Option) (*Layout, error) {
+	tokens, err := lexFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config{
+		workers:   runtime.GOMAXPROCS(0),
+		chunkSize: defaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	fn, err := makeStateFn(tokens, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Layout{format: format, fn: fn, cfg: cfg, naive: isNaive(tokens)}, nil
+}
+
+// isNaive reports whether the format logs a time without timezone offset,
+// that is using %{...}t directives only, none of them rendering the offset nor
+// the time since the epoch.
+func isNaive(tokens []token) bool {
+	var naive bool
+	for _, tok := range tokens {
+		if tok.typ != tokenDirective {
+			continue
+		}
+		d := parseDirective(tok.val)
+		if d.Format != TIME {
+			continue
+		}
+		if d.Param == "" {
+			return false
+		}
+		if tf, _ := parseTimeFormat(d.Param); tf.parts&timeZone != 0 {
+			return false
+		}
+		naive = true
+	}
+	return naive
+}
+
+// MustCompile is like Compile but panics if the format cannot be compiled. It
+// simplifies the initialization of global variables holding layouts.
+func MustCompile(format string, opts ...Option) *Layout {
+	l, err := Compile(format, opts...)
+	if err != nil {
+		panic("apachelog: Compile(" + format + "): " + err.Error())
+	}
+	return l
+}
+
+// String returns the format the layout has been compiled from.
+func (l *Layout) String() string {
+	return l.format
+}
+
+// Directives returns the directives and literal texts of the format the layout
+// has been compiled from, as ParseFormat does.
+func (l *Layout) Directives() []Directive {
+	directives, _ := ParseFormat(l.format)
+	return directives
+}
+
+// NewParser creates a new parser that reads from r and that parses log entries
+// using the layout.
+func (l *Layout) NewParser(r io.Reader) (*Parser, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	return &Parser{
+		br:     bufio.NewReader(r),
+		layout: l,
+	}, nil
+}
+
+// NewParallelParser creates a new parallel parser that reads size bytes from r
+// and that parses log entries using the layout.
+func (l *Layout) NewParallelParser(r io.ReaderAt, size int64) (*ParallelParser, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	return &ParallelParser{r: r, size: size, layout: l}, nil
+}
+
+// ParseLine parses a single log line, which may end with a "\n" or "\r\n"
+// line terminator, or none. Malformed lines are reported using a *ParseError.
+func (l *Layout) ParseLine(line string) (*AccessLogEntry, error) {
+	entry := AccessLogEntry{
+		Cookies: make(map[string]string),
+		Headers: make(map[string]string),
+		EnvVars: make(map[string]string),
+	}
+	if err := l.parseLine(&entry, line); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ParseBytes parses a single log line into entry, which is reset beforehand.
+// See Parser.ParseBytes for details.
+func (l *Layout) ParseBytes(line []byte, entry *AccessLogEntry) error {
+	return l.parseLine(entry, string(line))
+}
+
+// parseLine resets entry and parses the given line into it, once stripped
+// from its line terminator. Errors are always reported using a *ParseError.
+func (l *Layout) parseLine(entry *AccessLogEntry, line string) error {
+	line = trimEOL(line)
+	entry.reset()
+	if err := advance(l.fn, entry, line, 0); err != nil {
+		perr, ok := err.(*ParseError)
+		if !ok {
+			perr = &ParseError{Err: err}
+		}
+		perr.Raw = line
+		return perr
+	}
+	if l.naive && l.cfg.defaultLoc != nil {
+		t := entry.Time
+		entry.Time = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(),
+			t.Second(), t.Nanosecond(), l.cfg.defaultLoc)
+	}
+	if l.cfg.location != nil && !entry.Time.IsZero() {
+		entry.Time = entry.Time.In(l.cfg.location)
+	}
+	return nil
+}
+
+// skip hands a skipped malformed line to the OnSkip function and writes it to
+// the DeadLetter writer of the layout, if any.
+func (l *Layout) skip(perr *ParseError) error {
+	cfg := l.cfg
+	if cfg.onSkip == nil && cfg.deadLetter == nil {
+		return nil
+	}
+	// The layout may be shared by parsers running on other goroutines.
+	l.skipMu.Lock()
+	defer l.skipMu.Unlock()
+	if cfg.onSkip != nil {
+		cfg.onSkip(perr)
+	}
+	if cfg.deadLetter != nil {
+		if _, err := io.WriteString(cfg.deadLetter, perr.Raw+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimEOL removes the "\n" or "\r\n" line terminator of line, if any.
+func trimEOL(line string) string {
+	if strings.HasSuffix(line, "\n") {
+		line = line[:len(line)-1]
+		if strings.HasSuffix(line, "\r") {
+			line = line[:len(line)-1]
+		}
+	}
+	return line
+}
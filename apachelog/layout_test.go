@@ -0,0 +1,229 @@
+package apachelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompile(t *testing.T) {
+	if _, err := Compile("%h %x"); err == nil {
+		t.Errorf("Compile(%q): expected error; got none", "%h %x")
+	}
+	l, err := Compile(CombinedLogFormat)
+	if err != nil {
+		t.Fatalf("Compile(%q): unexpected error %q", CombinedLogFormat, err.Error())
+	}
+	if got := l.String(); got != CombinedLogFormat {
+		t.Errorf("Compile(%q).String(): got %q", CombinedLogFormat, got)
+	}
+}
+
+func TestLayout_Directives(t *testing.T) {
+	l := MustCompile(`%h "%{User-Agent}i"`)
+	want := []Directive{
+		{Format: REMOTE_HOST},
+		{Format: UNKNOWN, Literal: " "},
+		{Format: HEADER, Param: "User-Agent", Quoted: true},
+	}
+	if got := l.Directives(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Directives(): got %+v; want %+v", got, want)
+	}
+}
+
+func TestLayout_concurrent(t *testing.T) {
+	l := MustCompile(CommonLogFormat)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			line := fmt.Sprintf(`127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /%d HTTP/1.1" 200 %d`, i, i)
+			entry, err := l.ParseLine(line)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if entry.ResponseSize != int64(i) {
+				errs <- fmt.Errorf("ParseLine(%q): got ResponseSize %d", line, entry.ResponseSize)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			input := strings.Repeat(fmt.Sprintf(`127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET / HTTP/1.1" 200 %d`+"\n", i), 10)
+			p, err := l.NewParser(strings.NewReader(input))
+			if err != nil {
+				errs <- err
+				return
+			}
+			var n int
+			for p.Next() {
+				if p.Entry().ResponseSize != int64(i) {
+					errs <- fmt.Errorf("Parse(): got ResponseSize %d; want %d", p.Entry().ResponseSize, i)
+				}
+				n++
+			}
+			if err := p.Err(); err != nil {
+				errs <- err
+			} else if n != 10 {
+				errs <- fmt.Errorf("Next(): got %d entries; want 10", n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLayout_concurrentSkip(t *testing.T) {
+	var deadLetter bytes.Buffer
+	var skipped int
+	l := MustCompile(CommonLogFormat,
+		DeadLetter(&deadLetter),
+		OnSkip(func(err *ParseError) { skipped++ }),
+	)
+
+	input := strings.Repeat("malformed\n", 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := l.NewParser(strings.NewReader(input))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for p.Next() {
+			}
+			if err := p.Err(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if skipped != 80 {
+		t.Errorf("OnSkip(...): got %d calls; want 80", skipped)
+	}
+	if got, want := deadLetter.String(), strings.Repeat(input, 8); got != want {
+		t.Errorf("DeadLetter(...): got %d bytes; want %d", len(got), len(want))
+	}
+}
+
+func TestLayout_ParseLine(t *testing.T) {
+	l := MustCompile(CommonLogFormat)
+
+	entry, err := l.ParseLine(`127.0.0.1 - frank [12/Dec/2016:10:57:30 +0100] "GET /a HTTP/1.1" 200 50122`)
+	if err != nil {
+		t.Fatalf("ParseLine(...): unexpected error %q", err.Error())
+	}
+	if got, want := entry.RemoteUser, "frank"; got != want {
+		t.Errorf("ParseLine(...): got RemoteUser %q; want %q", got, want)
+	}
+
+	var perr *ParseError
+	if _, err := l.ParseLine("127.0.0.1 - frank foo"); !errors.As(err, &perr) {
+		t.Errorf("ParseLine(...): got error %v; want a *ParseError", err)
+	}
+}
+
+func TestLayout_ParseLine_terminators(t *testing.T) {
+	l := MustCompile(CombinedLogFormat)
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a HTTP/1.0" 200 2326 "-" "Mozilla/4.08"`
+
+	for _, eol := range []string{"", "\n", "\r\n"} {
+		entry, err := l.ParseLine(line + eol)
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", line+eol, err.Error())
+			continue
+		}
+		if got, want := entry.Headers["User-agent"], "Mozilla/4.08"; got != want {
+			t.Errorf("ParseLine(%q): got User-agent %q; want %q", line+eol, got, want)
+		}
+	}
+
+	l = MustCompile("%h %>s")
+	for _, eol := range []string{"", "\n", "\r\n"} {
+		entry, err := l.ParseLine("127.0.0.1 404" + eol)
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", "127.0.0.1 404"+eol, err.Error())
+			continue
+		}
+		if got, want := entry.Status, "404"; got != want {
+			t.Errorf("ParseLine(%q): got Status %q; want %q", "127.0.0.1 404"+eol, got, want)
+		}
+	}
+}
+
+func TestLayout_locations(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("time.LoadLocation(): %v", err)
+	}
+	want := time.Date(2000, time.October, 10, 20, 55, 36, 0, time.UTC)
+	testCases := []struct {
+		format string
+		line   string
+		opts   []Option
+		want   time.Time
+		loc    *time.Location
+	}{
+		{CommonLogFormat, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 -`, []Option{UTC()}, want, time.UTC},
+		{CommonLogFormat, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 -`, []Option{InLocation(paris)}, want, paris},
+		{CommonLogFormat, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 -`, []Option{DefaultLocation(paris), UTC()}, want, time.UTC},
+		{"%h %{%F %T}t", "127.0.0.1 2000-10-10 20:55:36", nil, want, time.UTC},
+		{"%h %{%F %T}t", "127.0.0.1 2000-10-10 22:55:36", []Option{DefaultLocation(paris)}, want, paris},
+		{"%h %{%F %T}t.%{msec_frac}t", "127.0.0.1 2000-10-10 22:55:36.000", []Option{DefaultLocation(paris), UTC()}, want, time.UTC},
+		{"%h %{%F %T %z}t", "127.0.0.1 2000-10-10 13:55:36 -0700", []Option{DefaultLocation(paris)}, want, nil},
+		{"%h %{sec}t", "127.0.0.1 971211336", []Option{DefaultLocation(paris)}, want, time.UTC},
+	}
+	for _, tc := range testCases {
+		entry, err := MustCompile(tc.format, tc.opts...).ParseLine(tc.line)
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", tc.line, err.Error())
+			continue
+		}
+		if !entry.Time.Equal(tc.want) {
+			t.Errorf("ParseLine(%q): got Time %v; want %v", tc.line, entry.Time, tc.want)
+		}
+		if tc.loc != nil && entry.Time.Location() != tc.loc {
+			t.Errorf("ParseLine(%q): got location %v; want %v", tc.line, entry.Time.Location(), tc.loc)
+		}
+	}
+}
+
+func TestRejectInvalidIP(t *testing.T) {
+	const format = `%a %A %!200{c}a %>s`
+	testCases := []struct {
+		line  string
+		valid bool
+	}{
+		{"10.0.0.1 10.0.0.2 - 200", true},
+		{"2001:db8::1 fe80::1%eth0 10.0.0.3 404", true},
+		{"www.example.com 10.0.0.2 - 200", false},
+		{"10.0.0.1 local - 200", false},
+		{"10.0.0.1 10.0.0.2 unknown 404", false},
+	}
+	for _, tc := range testCases {
+		if _, err := MustCompile(format).ParseLine(tc.line); err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", tc.line, err.Error())
+		}
+		_, err := MustCompile(format, RejectInvalidIP()).ParseLine(tc.line)
+		if tc.valid && err != nil {
+			t.Errorf("ParseLine(%q) with RejectInvalidIP: unexpected error %q", tc.line, err.Error())
+		}
+		var perr *ParseError
+		if !tc.valid && !errors.As(err, &perr) {
+			t.Errorf("ParseLine(%q) with RejectInvalidIP: got %v; want a *ParseError", tc.line, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package apachelog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tokenType identifies the type of a format string token.
+type tokenType int
+
+const (
+	tokenLiteral   tokenType = iota // literal text, such as spaces or brackets
+	tokenDirective                  // directive, such as %h or %>s
+)
+
+// A token is a piece of a format string, as returned by lexFormat.
+type token struct {
+	typ    tokenType
+	val    string // literal text or directive, including its modifiers
+	quoted bool   // directive enclosed in double quotes
+}
+
+// lexFormat splits a format string, as defined by the mod_log_config Apache
+// module, into a list of directive and literal tokens.
+//
+// As Apache does, the \n and \t escape sequences are interpreted and %% stands
+// for a literal percent sign. Directives directly enclosed in double quotes
+// are marked as quoted, in which case the quotes are removed from the
+// surrounding literals.
+//
+// Directives are not validated, only their syntax is checked. Consecutive
+// literals are merged together and empty literals are discarded.
+func lexFormat(format string) ([]token, error) {
+	var tokens []token
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			tokens = append(tokens, token{typ: tokenLiteral, val: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		switch c := format[i]; c {
+		case '\\':
+			if i+1 < len(format) {
+				switch format[i+1] {
+				case 'n':
+					lit.WriteByte('\n')
+					i++
+					continue
+				case 't':
+					lit.WriteByte('\t')
+					i++
+					continue
+				}
+			}
+			lit.WriteByte(c)
+		case '%':
+			if i+1 < len(format) && format[i+1] == '%' {
+				lit.WriteByte('%')
+				i++
+				continue
+			}
+			n, err := lexDirective(format[i:])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			tokens = append(tokens, token{typ: tokenDirective, val: format[i : i+n]})
+			i += n - 1
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+
+	return markQuoted(tokens), nil
+}
+
+// lexDirective returns the length of the directive starting at the beginning
+// of s, which is expected to start with a % character.
+func lexDirective(s string) (int, error) {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '!' || c == '<' || c == '>' || c == ',' || (c >= '0' && c <= '9'):
+			// modifier
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return 0, fmt.Errorf("%q format is missing a closing '}'", s)
+			}
+			i += end
+		default:
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("format ends with an incomplete directive")
+}
+
+// markQuoted marks the directives enclosed in double quotes as quoted and
+// removes the quotes from the surrounding literals.
+func markQuoted(tokens []token) []token {
+	for i := range tokens {
+		if tokens[i].typ != tokenDirective || i == 0 || i == len(tokens)-1 {
+			continue
+		}
+		prev, next := &tokens[i-1], &tokens[i+1]
+		if prev.typ != tokenLiteral || !strings.HasSuffix(prev.val, "\"") ||
+			next.typ != tokenLiteral || !strings.HasPrefix(next.val, "\"") {
+			continue
+		}
+		tokens[i].quoted = true
+		prev.val = prev.val[:len(prev.val)-1]
+		next.val = next.val[1:]
+	}
+
+	// Remove the literals that have been emptied.
+	n := 0
+	for _, tok := range tokens {
+		if tok.typ == tokenLiteral && tok.val == "" {
+			continue
+		}
+		tokens[n] = tok
+		n++
+	}
+	return tokens[:n]
+}
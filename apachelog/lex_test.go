@@ -0,0 +1,75 @@
+package apachelog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLexFormat(t *testing.T) {
+	type testCase struct {
+		in   string
+		want []token
+		err  error
+	}
+
+	testCases := []testCase{
+		{
+			in: CommonLogFormat,
+			want: []token{
+				{typ: tokenDirective, val: "%h"},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%l"},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%u"},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%t"},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%r", quoted: true},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%s"},
+				{typ: tokenLiteral, val: " "},
+				{typ: tokenDirective, val: "%b"},
+			},
+		},
+		{
+			in: `%h:%p [%{X-Id}i] %D\t%>s 100%%`,
+			want: []token{
+				{typ: tokenDirective, val: "%h"},
+				{typ: tokenLiteral, val: ":"},
+				{typ: tokenDirective, val: "%p"},
+				{typ: tokenLiteral, val: " ["},
+				{typ: tokenDirective, val: "%{X-Id}i"},
+				{typ: tokenLiteral, val: "] "},
+				{typ: tokenDirective, val: "%D"},
+				{typ: tokenLiteral, val: "\t"},
+				{typ: tokenDirective, val: "%>s"},
+				{typ: tokenLiteral, val: " 100%"},
+			},
+		},
+		{
+			in: `"%{X Forwarded}i""%400,501{User-agent}i"`,
+			want: []token{
+				{typ: tokenDirective, val: "%{X Forwarded}i", quoted: true},
+				{typ: tokenDirective, val: "%400,501{User-agent}i", quoted: true},
+			},
+		},
+		{in: "%h %", err: errors.New("format ends with an incomplete directive")},
+		{in: "%{Referer", err: errors.New("\"%{Referer\" format is missing a closing '}'")},
+	}
+
+	for i, test := range testCases {
+		got, err := lexFormat(test.in)
+		switch {
+		case err == nil && test.err != nil:
+			t.Errorf("%d. lexFormat(%q): expected error %q; got none", i, test.in, test.err.Error())
+		case err != nil && test.err == nil:
+			t.Errorf("%d. lexFormat(%q): unexpected error %q", i, test.in, err.Error())
+		case err != nil && test.err != nil && err.Error() != test.err.Error():
+			t.Errorf("%d. lexFormat(%q): expected error %q; got %q", i, test.in, test.err.Error(), err.Error())
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. lexFormat(%q): got %+v; want %+v", i, test.in, got, test.want)
+		}
+	}
+}
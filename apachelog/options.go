@@ -0,0 +1,116 @@
+package apachelog
+
+import (
+	"io"
+	"time"
+)
+
+// An Option configures a Parser.
+type Option func(*config)
+
+// config holds the settings of a parser.
+type config struct {
+	lenient    bool
+	onSkip     func(*ParseError)
+	deadLetter io.Writer
+	raw        bool
+	workers    int
+	chunkSize  int64
+	strictIP   bool           // %a and %A values must be IP addresses
+	location   *time.Location // location the times are converted to
+	defaultLoc *time.Location // location of the times logged without offset
+}
+
+// Lenient makes the parser skip the malformed lines instead of returning an
+// error. The number of skipped lines is available through Parser.Skipped.
+func Lenient() Option {
+	return func(c *config) {
+		c.lenient = true
+	}
+}
+
+// OnSkip makes the parser lenient and calls fn with the error of every
+// malformed line that is skipped.
+func OnSkip(fn func(err *ParseError)) Option {
+	return func(c *config) {
+		c.lenient = true
+		c.onSkip = fn
+	}
+}
+
+// DeadLetter makes the parser lenient and writes every malformed line that is
+// skipped to w, as is. A failure to write to w is reported by Parse.
+func DeadLetter(w io.Writer) Option {
+	return func(c *config) {
+		c.lenient = true
+		c.deadLetter = w
+	}
+}
+
+// KeepRaw makes the parser store the values as logged by Apache, instead of
+// unescaping them. Apache escapes double quotes, backslashes and control
+// characters, for instance in the request line and headers.
+func KeepRaw() Option {
+	return func(c *config) {
+		c.raw = true
+	}
+}
+
+// Workers sets the number of goroutines used by a ParallelParser. It defaults
+// to GOMAXPROCS.
+func Workers(n int) Option {
+	return func(c *config) {
+		if n < 1 {
+			n = 1
+		}
+		c.workers = n
+	}
+}
+
+// ChunkSize sets the size, in bytes, of the chunks processed by each goroutine
+// of a ParallelParser. Chunks are extended up to the end of their last line.
+// It defaults to 4 MiB.
+func ChunkSize(n int64) Option {
+	return func(c *config) {
+		if n < 1 {
+			n = 1
+		}
+		c.chunkSize = n
+	}
+}
+
+// RejectInvalidIP makes the parser treat the lines whose remote or local IP
+// address (%a or %A) is not a valid IP address as malformed. Combined with
+// Lenient or OnSkip, such lines are skipped, or flagged, instead.
+//
+// Without this option, the addresses are not validated, and
+// AccessLogEntry.RemoteAddr returns an invalid netip.Addr for such lines.
+func RejectInvalidIP() Option {
+	return func(c *config) {
+		c.strictIP = true
+	}
+}
+
+// InLocation makes the parser convert the time of the entries to loc, so that
+// entries logged by servers in different timezones can be compared and sorted
+// consistently.
+func InLocation(loc *time.Location) Option {
+	return func(c *config) {
+		c.location = loc
+	}
+}
+
+// UTC makes the parser convert the time of the entries to UTC.
+func UTC() Option {
+	return InLocation(time.UTC)
+}
+
+// DefaultLocation sets the location of the times logged without timezone
+// offset, using %{...}t directives such as %{%Y-%m-%d %H:%M:%S}t. Such times
+// are otherwise assumed to be in UTC. It does not apply to the times logged
+// with an offset, nor to the times since the epoch.
+func DefaultLocation(loc *time.Location) Option {
+	return func(c *config) {
+		c.defaultLoc = loc
+	}
+}
@@ -0,0 +1,283 @@
+package apachelog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Default settings of a ParallelParser.
+const (
+	defaultChunkSize = 4 << 20 // 4 MiB
+)
+
+// maxParallelErrors is the number of malformed lines reported by the Err
+// method of a ParallelParser. Others are only counted.
+const maxParallelErrors = 100
+
+// A ParallelParser parses a log file on several goroutines. The input is split
+// into line-aligned chunks, which are parsed concurrently using the same
+// compiled format.
+//
+// Malformed lines do not stop the parsing: they are skipped, in input order,
+// as a lenient Parser does. They are handed to the OnSkip function and written
+// to the DeadLetter writer of the layout, if any, and counted by Skipped. Err
+// reports the first ones once the parsing is over.
+type ParallelParser struct {
+	r      io.ReaderAt
+	size   int64
+	layout *Layout
+
+	mu      sync.Mutex
+	errs    []error
+	skipped int
+}
+
+// A chunk is a line-aligned part of the input of a ParallelParser.
+type chunk struct {
+	start, end int64
+
+	entries  []*AccessLogEntry // parsed entries, when the order is preserved
+	errs     []*ParseError     // malformed lines
+	lines    int               // number of lines of the chunk
+	complete bool              // the whole chunk has been parsed
+	err      error             // error preventing the chunk from being parsed
+	done     chan struct{}     // closed once the chunk has been parsed
+}
+
+// NewParallelParser creates a new parser that reads size bytes from r and that
+// is capable of parsing log entries having the given format. See CustomParser
+// for details about the supported formats.
+//
+// The number of goroutines and the size of the chunks can be set using the
+// Workers and ChunkSize options. The parser always skips the malformed lines,
+// which the OnSkip and DeadLetter options let handle.
+func NewParallelParser(r io.ReaderAt, size int64, format string, opts ...Option) (*ParallelParser, error) {
+	l, err := Compile(format, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return l.NewParallelParser(r, size)
+}
+
+// Parse starts parsing the input and returns a channel delivering the entries
+// in their original order. The channel is closed once the whole input has been
+// parsed, or as soon as ctx is done or an I/O error occurs. Err must then be
+// called to check for errors.
+func (pp *ParallelParser) Parse(ctx context.Context) <-chan *AccessLogEntry {
+	return pp.run(ctx, true)
+}
+
+// ParseUnordered is like Parse but delivers the entries as soon as they are
+// parsed, regardless of their original order, which requires less memory.
+func (pp *ParallelParser) ParseUnordered(ctx context.Context) <-chan *AccessLogEntry {
+	return pp.run(ctx, false)
+}
+
+// Err returns the errors that occurred during the parsing, joined together, or
+// nil if there were none. The first malformed lines, up to 100, are reported
+// using a *ParseError, which can be retrieved using errors.As. Err must only
+// be called once the channel returned by Parse or ParseUnordered has been
+// closed.
+//
+// Once the parsing has been interrupted, by ctx or by an I/O error, the line
+// numbers of the following chunks are unknown, so their malformed lines are
+// not reported.
+func (pp *ParallelParser) Err() error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return errors.Join(pp.errs...)
+}
+
+// Skipped returns the number of malformed lines skipped. It must only be
+// called once the channel returned by Parse or ParseUnordered has been closed.
+func (pp *ParallelParser) Skipped() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.skipped
+}
+
+func (pp *ParallelParser) run(parent context.Context, ordered bool) <-chan *AccessLogEntry {
+	out := make(chan *AccessLogEntry, pp.layout.cfg.workers)
+	chunks := make(chan *chunk, pp.layout.cfg.workers)  // chunks to parse
+	pending := make(chan *chunk, pp.layout.cfg.workers) // chunks in input order
+
+	// The parsing is canceled internally as soon as an I/O error occurs.
+	ctx, cancel := context.WithCancel(parent)
+
+	// Split the input into chunks.
+	go func() {
+		defer close(chunks)
+		defer close(pending)
+		var start int64
+		for start < pp.size {
+			end, err := pp.lineStart(start + pp.layout.cfg.chunkSize)
+			c := &chunk{start: start, end: end, err: err, done: make(chan struct{})}
+			select {
+			case pending <- c:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				close(c.done)
+				return
+			}
+			select {
+			case chunks <- c:
+			case <-ctx.Done():
+				close(c.done)
+				return
+			}
+			start = end
+		}
+	}()
+
+	// Parse the chunks.
+	var wg sync.WaitGroup
+	for i := 0; i < pp.layout.cfg.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				pp.parseChunk(ctx, c, ordered, out)
+				close(c.done)
+			}
+		}()
+	}
+
+	// Deliver the entries and handle the malformed lines, in input order.
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		var perrs, errs []error
+		var skipped, lines int
+		known := true // line numbers are known up to the current chunk
+		for c := range pending {
+			<-c.done
+			if c.err != nil {
+				errs = append(errs, c.err)
+				known = false
+				cancel()
+				continue
+			}
+			for _, entry := range c.entries {
+				select {
+				case out <- entry:
+				case <-ctx.Done():
+				}
+			}
+			c.entries = nil
+
+			// Line numbers are relative to the chunk, and unknown once a
+			// previous chunk has not been parsed completely.
+			for _, perr := range c.errs {
+				if !known {
+					break
+				}
+				perr.Line += lines
+				skipped++
+				if len(perrs) < maxParallelErrors {
+					perrs = append(perrs, perr)
+				}
+				if err := pp.layout.skip(perr); err != nil {
+					errs = append(errs, err)
+					known = false
+					cancel()
+				}
+			}
+			c.errs = nil
+			known = known && c.complete
+			lines += c.lines
+		}
+		wg.Wait()
+
+		if skipped > len(perrs) {
+			perrs = append(perrs, fmt.Errorf("%d more malformed lines skipped", skipped-len(perrs)))
+		}
+		errs = append(perrs, errs...)
+		if err := parent.Err(); err != nil {
+			errs = append(errs, err)
+		}
+
+		pp.mu.Lock()
+		pp.errs = errs
+		pp.skipped = skipped
+		pp.mu.Unlock()
+	}()
+
+	return out
+}
+
+// parseChunk parses the lines of the given chunk. Entries are stored in the
+// chunk when ordered, or directly sent to out otherwise.
+func (pp *ParallelParser) parseChunk(ctx context.Context, c *chunk, ordered bool, out chan<- *AccessLogEntry) {
+	p := &Parser{
+		br:     bufio.NewReader(io.NewSectionReader(pp.r, c.start, c.end-c.start)),
+		layout: pp.layout,
+		offset: c.start,
+	}
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		entry := &AccessLogEntry{
+			Cookies: make(map[string]string),
+			Headers: make(map[string]string),
+			EnvVars: make(map[string]string),
+		}
+		err := p.parseInto(entry)
+		var perr *ParseError
+		switch {
+		case err == io.EOF:
+			c.lines = p.line
+			c.complete = true
+			return
+		case errors.As(err, &perr):
+			c.errs = append(c.errs, perr)
+			continue
+		case err != nil:
+			c.err = err
+			return
+		}
+		if ordered {
+			c.entries = append(c.entries, entry)
+			continue
+		}
+		select {
+		case out <- entry:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lineStart returns the offset of the first line starting at or after off, or
+// the size of the input if there is none.
+func (pp *ParallelParser) lineStart(off int64) (int64, error) {
+	if off >= pp.size {
+		return pp.size, nil
+	}
+	// The line starts right after the previous newline character.
+	off--
+	buf := make([]byte, 4096)
+	for off < pp.size {
+		n, err := pp.r.ReadAt(buf, off)
+		for i := 0; i < n; i++ {
+			if buf[i] == '\n' {
+				return off + int64(i) + 1, nil
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return pp.size, nil
+}
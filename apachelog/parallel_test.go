@@ -0,0 +1,193 @@
+package apachelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func makeParallelInput(n int, malformed map[int]bool) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if malformed[i] {
+			fmt.Fprintf(&b, "127.0.0.1 - - [12/Dec/2016 10:57:30] \"GET /%d HTTP/1.1\" 200 %d\n", i, i)
+			continue
+		}
+		fmt.Fprintf(&b, "127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] \"GET /%d HTTP/1.1\" 200 %d\n", i, i)
+	}
+	return b.String()
+}
+
+func TestParallelParser_Parse(t *testing.T) {
+	malformed := map[int]bool{7: true, 500: true}
+	input := makeParallelInput(1000, malformed)
+
+	pp, err := NewParallelParser(strings.NewReader(input), int64(len(input)), CommonLogFormat,
+		Workers(4), ChunkSize(1000))
+	if err != nil {
+		t.Fatalf("NewParallelParser(...): unexpected error %q", err.Error())
+	}
+
+	want := int64(1)
+	for entry := range pp.Parse(context.Background()) {
+		for malformed[int(want)] {
+			want++
+		}
+		if entry.ResponseSize != want {
+			t.Fatalf("Parse(...): got entry %d; want %d", entry.ResponseSize, want)
+		}
+		want++
+	}
+	if want != 1001 {
+		t.Errorf("Parse(...): stopped at entry %d; want 1001", want)
+	}
+
+	err = pp.Err()
+	var lines []int
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var perr *ParseError
+		if !errors.As(e, &perr) {
+			t.Fatalf("Err(): got error %v; want a *ParseError", e)
+		}
+		lines = append(lines, perr.Line)
+	}
+	if fmt.Sprint(lines) != "[7 500]" {
+		t.Errorf("Err(): got errors on lines %v; want [7 500]", lines)
+	}
+}
+
+func TestParallelParser_ParseUnordered(t *testing.T) {
+	input := makeParallelInput(1000, nil)
+
+	pp, err := NewParallelParser(strings.NewReader(input), int64(len(input)), CommonLogFormat,
+		Workers(3), ChunkSize(777))
+	if err != nil {
+		t.Fatalf("NewParallelParser(...): unexpected error %q", err.Error())
+	}
+
+	var sizes []int
+	for entry := range pp.ParseUnordered(context.Background()) {
+		sizes = append(sizes, int(entry.ResponseSize))
+	}
+	if err := pp.Err(); err != nil {
+		t.Fatalf("Err(): unexpected error %q", err.Error())
+	}
+	sort.Ints(sizes)
+	for i, size := range sizes {
+		if size != i+1 {
+			t.Fatalf("ParseUnordered(...): got entry %d at index %d", size, i)
+		}
+	}
+	if len(sizes) != 1000 {
+		t.Errorf("ParseUnordered(...): got %d entries; want 1000", len(sizes))
+	}
+}
+
+func TestParallelParser_cancel(t *testing.T) {
+	input := makeParallelInput(1000, nil)
+
+	pp, err := NewParallelParser(strings.NewReader(input), int64(len(input)), CommonLogFormat,
+		Workers(2), ChunkSize(100))
+	if err != nil {
+		t.Fatalf("NewParallelParser(...): unexpected error %q", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var n int
+	for range pp.Parse(ctx) {
+		if n++; n == 10 {
+			cancel()
+		}
+	}
+	if err := pp.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err(): got %v; want context.Canceled", err)
+	}
+}
+
+func TestParallelParser_skip(t *testing.T) {
+	malformed := make(map[int]bool)
+	for i := 3; i <= 1000; i += 6 {
+		malformed[i] = true
+	}
+	input := makeParallelInput(1000, malformed)
+
+	var deadLetter strings.Builder
+	var lines []int
+	pp, err := NewParallelParser(strings.NewReader(input), int64(len(input)), CommonLogFormat,
+		Workers(4), ChunkSize(1000),
+		DeadLetter(&deadLetter),
+		OnSkip(func(err *ParseError) { lines = append(lines, err.Line) }),
+	)
+	if err != nil {
+		t.Fatalf("NewParallelParser(...): unexpected error %q", err.Error())
+	}
+	for range pp.ParseUnordered(context.Background()) {
+	}
+
+	var want []int
+	var wantDeadLetter strings.Builder
+	split := strings.SplitAfter(input, "\n")
+	for i := 1; i <= 1000; i++ {
+		if malformed[i] {
+			want = append(want, i)
+			wantDeadLetter.WriteString(split[i-1])
+		}
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("OnSkip(...): got lines %v; want %v", lines, want)
+	}
+	if got := deadLetter.String(); got != wantDeadLetter.String() {
+		t.Errorf("DeadLetter(...): got %d bytes; want %d", len(got), wantDeadLetter.Len())
+	}
+	if got := pp.Skipped(); got != len(want) {
+		t.Errorf("Skipped(): got %d; want %d", got, len(want))
+	}
+
+	errs := pp.Err().(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != maxParallelErrors+1 {
+		t.Fatalf("Err(): got %d errors; want %d", len(errs), maxParallelErrors+1)
+	}
+	for i, e := range errs[:maxParallelErrors] {
+		var perr *ParseError
+		if !errors.As(e, &perr) || perr.Line != want[i] {
+			t.Errorf("Err(): got error %v; want a *ParseError on line %d", e, want[i])
+		}
+	}
+	wantMsg := fmt.Sprintf("%d more malformed lines skipped", len(want)-maxParallelErrors)
+	if got := errs[maxParallelErrors].Error(); got != wantMsg {
+		t.Errorf("Err(): got last error %q; want %q", got, wantMsg)
+	}
+}
+
+func TestParallelParser_cancelLines(t *testing.T) {
+	malformed := make(map[int]bool)
+	for i := 5; i <= 1000; i += 5 {
+		malformed[i] = true
+	}
+	input := makeParallelInput(1000, malformed)
+
+	pp, err := NewParallelParser(strings.NewReader(input), int64(len(input)), CommonLogFormat,
+		Workers(4), ChunkSize(300))
+	if err != nil {
+		t.Fatalf("NewParallelParser(...): unexpected error %q", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var n int
+	for range pp.ParseUnordered(ctx) {
+		if n++; n == 20 {
+			cancel()
+		}
+	}
+	for _, e := range pp.Err().(interface{ Unwrap() []error }).Unwrap() {
+		var perr *ParseError
+		if errors.As(e, &perr) && !malformed[perr.Line] {
+			t.Errorf("Err(): got error on line %d, which is well-formed", perr.Line)
+		}
+	}
+}
@@ -5,17 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Most commonly used log formats.
-const (
-	CombinedLogFromat = "%h %l %u %t \"%r\" %s %b \"%{Referer}i\" \"%{User-agent}i\""
-	CommonLogFormat   = "%h %l %u %t \"%r\" %s %b"
-)
-
 // StandardEnglishFormat is the time layout to use for parsing %t format.
 const StandardEnglishFormat = "02/Jan/2006:15:04:05 -0700"
 
@@ -24,360 +20,976 @@ const StandardEnglishFormat = "02/Jan/2006:15:04:05 -0700"
 // information.
 type stateFn func(entry *AccessLogEntry, line string, pos int) error
 
-// makeStateFn constructs a chain of state functions from an expression, which
-// corresponds to a list of format strings as defined by the Apache
-// mod_log_config module documentation:
+// A field describes how the value of a directive is delimited in a line.
+type field struct {
+	quoted bool   // the value is enclosed in double quotes
+	delim  string // literal text following the value, if any
+	raw    bool   // the value is kept escaped
+	last   bool   // the value is the last one of the line
+}
+
+// makeStateFn constructs a chain of state functions from a list of tokens,
+// which corresponds to a format string as defined by the Apache mod_log_config
+// module documentation:
 //    https://httpd.apache.org/docs/2.2/fr/mod/mod_log_config.html#formats
-func makeStateFn(expr []string) (stateFn, error) {
+func makeStateFn(tokens []token, cfg config) (stateFn, error) {
 	// End of the recursive call, we return nil.
-	if expr == nil || len(expr) == 0 {
+	if len(tokens) == 0 {
 		return nil, nil
 	}
 
-	formatStr := expr[0]
-
-	// Expressions can be quoted, so we keep a track of it and trim the quotes.
-	var quoted bool
-	if strings.HasPrefix(formatStr, "\"") {
-		quoted = true
-		formatStr = strings.Trim(formatStr, "\"")
-	}
-
 	// Recursive call to determine the next state function.
 	// XXX(gilliek): errors are reported right to left
-	next, err := makeStateFn(expr[1:])
+	next, err := makeStateFn(tokens[1:], cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	switch f := LookupFormat(formatStr); f {
+	tok := tokens[0]
+	if tok.typ == tokenLiteral {
+		return annotate("", UNKNOWN, parseLiteral(tok.val, next)), nil
+	}
+
+	// Unquoted values extend up to the literal text that follows them.
+	f := field{quoted: tok.quoted, raw: cfg.raw}
+	if len(tokens) > 1 && tokens[1].typ == tokenLiteral {
+		f.delim = tokens[1].val
+	}
+	f.last = len(tokens) == 1
+
+	d := parseDirective(tok.val)
+	fn := makeDirectiveFn(d, f, next)
+	if fn == nil {
+		return nil, fmt.Errorf("%q format is not supported", tok.val)
+	}
+	if cfg.strictIP && (d.Format == REMOTE_IP_ADDRESS || d.Format == LOCAL_IP_ADDRESS) {
+		fn = checkIPAddr(f, fn)
+	}
+	if len(d.Conditions) > 0 {
+		// Apache logs a "-" instead of the value when the status code does not
+		// meet the conditions, in which case the field is left unset.
+		fn = parseAbsent(f, fn, next)
+	}
+	fn = markPresent(d.Format, f, fn)
+	return annotate(tok.val, d.Format, fn), nil
+}
+
+// annotate wraps fn so that the errors it reports are turned into a ParseError
+// holding the position of the value in the line and the directive that failed
+// to parse it. Errors already annotated by the next state functions are
+// returned as is.
+func annotate(directive string, format Format, fn stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		err := fn(entry, line, pos)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*ParseError); ok {
+			return err
+		}
+		return &ParseError{Column: pos + 1, Directive: directive, Format: format, Err: err}
+	}
+}
+
+// makeDirectiveFn returns the state function extracting the information
+// described by the given directive, or nil if the directive is not supported.
+func makeDirectiveFn(d Directive, f field, next stateFn) stateFn {
+	switch d.Format {
 	case REMOTE_HOST:
-		return parseRemoteHost(quoted, next), nil
+		return parseRemoteHost(f, next)
 	case REMOTE_LOGNAME:
-		return parseRemoteLogname(quoted, next), nil
+		return parseRemoteLogname(f, next)
 	case REMOTE_USER:
-		return parseRemoteUser(quoted, next), nil
+		return parseRemoteUser(f, next)
 	case TIME:
-		return parseTime(quoted, next), nil
+		if d.Param == "" {
+			return parseTime(f, next)
+		}
+		tf, err := parseTimeFormat(d.Param)
+		if err != nil {
+			return nil
+		}
+		return parseTimeFormatted(f, tf, next)
 	case REQUEST_FIRST_LINE:
-		return parseRequestFirstLine(quoted, next), nil
+		return parseRequestFirstLine(f, next)
 	case STATUS:
-		return parseStatus(quoted, next), nil
+		return parseStatus(f, next)
 	case RESPONSE_SIZE:
-		return parseResponseSize(quoted, next), nil
+		return parseResponseSize(f, next)
 	case RESPONSE_SIZE_CLF:
-		return parseResponseSizeCLF(quoted, next), nil
+		return parseResponseSizeCLF(f, next)
 	case ELAPSED_TIME_IN_SEC:
-		return parseElapsedTimeInSec(quoted, next), nil
+		switch d.Param {
+		case "ms":
+			return parseElapsedTimeIn(f, 1000, next)
+		case "us":
+			return parseElapsedTimeIn(f, 1, next)
+		}
+		return parseElapsedTimeInSec(f, next)
 	case HEADER:
-		hdr := strings.TrimSuffix(strings.TrimPrefix(formatStr, "%{"), "}i")
-		return parseHeader(quoted, hdr, next), nil
-	case UNKNOWN:
-		fallthrough
-	default:
-		return nil, fmt.Errorf("%q format is not supported", formatStr)
+		return parseHeader(f, d.Param, next)
+	case REMOTE_IP_ADDRESS:
+		if d.Param == "c" {
+			return parsePeerIPAddr(f, next)
+		}
+		return parseRemoteIPAddr(f, next)
+	case LOCAL_IP_ADDRESS:
+		return parseLocalIPAddr(f, next)
+	case COOKIE:
+		return parseCookie(f, d.Param, next)
+	case ELAPSED_TIME:
+		return parseElapsedTime(f, next)
+	case ENV_VAR:
+		return parseEnvVar(f, d.Param, next)
+	case FILENAME:
+		return parseFilename(f, next)
+	case REQUEST_PROTO:
+		return parseRequestProto(f, next)
+	case REQUEST_METHOD:
+		return parseRequestMethod(f, next)
+	case PORT:
+		switch d.Param {
+		case "local":
+			return parseLocalPort(f, next)
+		case "remote":
+			return parseRemotePort(f, next)
+		}
+		return parsePort(f, next)
+	case PROCESS_ID:
+		switch d.Param {
+		case "tid":
+			return parseThreadID(f, 10, next)
+		case "hextid":
+			return parseThreadID(f, 16, next)
+		}
+		return parseProcessID(f, next)
+	case QUERY_STRING:
+		return parseQueryString(f, next)
+	case URL_PATH:
+		return parseURLPath(f, next)
+	case CANONICAL_SERVER_NAME:
+		return parseCanonicalServerName(f, next)
+	case SERVER_NAME:
+		return parseServerName(f, next)
+	case BYTES_RECEIVED:
+		return parseBytesReceived(f, next)
+	case BYTES_SENT:
+		return parseBytesSent(f, next)
 	}
+	return nil
 }
 
 // A Parser for parsing Apaache access log files.
 type Parser struct {
-	br *bufio.Reader
-	fn stateFn
+	br     *bufio.Reader
+	layout *Layout
+
+	entry *AccessLogEntry // last entry parsed by Next
+	err   error           // first error encountered by Next
+
+	line    int   // number of lines read
+	offset  int64 // offset of the next line in the input
+	skipped int   // number of malformed lines skipped in lenient mode
 }
 
 // CombinedParser creates a new parser that reads from r and that parses log
 // entries using the Apache Combined Log format.
-func CombinedParser(r io.Reader) (*Parser, error) {
-	return CustomParser(r, CombinedLogFromat)
+func CombinedParser(r io.Reader, opts ...Option) (*Parser, error) {
+	return CustomParser(r, CombinedLogFormat, opts...)
 }
 
 // CommonParser creates a new parser that reads from r and that parses log entries
 // using the Apache Common Log format.
-func CommonParser(r io.Reader) (*Parser, error) {
-	return CustomParser(r, CommonLogFormat)
+func CommonParser(r io.Reader, opts ...Option) (*Parser, error) {
+	return CustomParser(r, CommonLogFormat, opts...)
 }
 
 // CustomParser creates a new parser that reads from r and that is capable of
 // parsing log entries having the given format.
 //
-// The format is mostly the same as the one defined by the mod_log_config Apache
+// The format is the same as the one defined by the mod_log_config Apache
 // module:
 //    https://httpd.apache.org/docs/2.4/en/mod/mod_log_config.html#formats
 //
-// However, unlike the Apache format, it does not support modifiers of any
-// kind (<, >, !, ...).
-func CustomParser(r io.Reader, format string) (*Parser, error) {
+// Any text surrounding the directives, such as spaces, brackets or tabs, is
+// expected to appear as is in the log entries. Unquoted values extend up to
+// the text following the directive.
+//
+// Modifiers (<, >, !, status codes and the {c}, {remote}, {local}, ...
+// variants) are supported as well. When a directive is conditioned on status
+// codes, Apache logs a "-" if the conditions are not met, and the
+// corresponding field of the entry is left unset.
+//
+// Besides %t, the time can be logged using %{format}t, where format is either
+// a strftime(3) format, or one of sec, msec, usec, msec_frac and usec_frac,
+// optionally prefixed by begin: or end:. Several such directives may be
+// combined to log a single time, such as "%{%d/%b/%Y %T}t.%{msec_frac}t", in
+// which case they all contribute to the Time of the entry. Times given since
+// the epoch are in UTC, as well as times logged without offset unless the
+// DefaultLocation option is given.
+//
+// The behavior of the parser can be tuned using options, such as Lenient.
+//
+// CustomParser compiles the format every time it is called. When the same
+// format is used to parse many inputs, use Compile and Layout.NewParser
+// instead.
+func CustomParser(r io.Reader, format string, opts ...Option) (*Parser, error) {
 	if r == nil {
 		return nil, errors.New("reader is nil")
 	}
-	fn, err := makeStateFn(strings.Split(format, " "))
+	l, err := Compile(format, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &Parser{
-		br: bufio.NewReader(r),
-		fn: fn,
-	}, nil
+	return l.NewParser(r)
 }
 
 // Parse the next access log entry. If there is no more data to read and parse,
-// an io.EOF error is returned.
+// an io.EOF error is returned. Malformed entries are reported using a
+// *ParseError, unless the parser is lenient in which case they are skipped.
 func (p *Parser) Parse() (*AccessLogEntry, error) {
-	line, err := p.br.ReadString('\n')
-	if err != nil {
-		return nil, err
-	}
 	entry := AccessLogEntry{
 		Cookies: make(map[string]string),
 		Headers: make(map[string]string),
 		EnvVars: make(map[string]string),
 	}
-	if err := p.fn(&entry, line, 0); err != nil {
+	if err := p.ParseInto(&entry); err != nil {
 		return nil, err
 	}
 	return &entry, nil
 }
 
-func parseRemoteHost(quoted bool, next stateFn) stateFn {
+// ParseInto parses the next access log entry into entry, which is reset
+// beforehand. Errors are reported the same way as Parse does, in which case
+// the content of the entry is undefined.
+//
+// Unlike Parse, ParseInto allows the same entry to be reused for every line,
+// which spares most of the allocations: the maps of the entry are cleared
+// instead of being reallocated, and are only allocated if needed. Parsing a
+// line then costs one allocation, for the string holding the line, which the
+// fields of the entry refer to.
+func (p *Parser) ParseInto(entry *AccessLogEntry) error {
+	for {
+		err := p.parseInto(entry)
+		perr, ok := err.(*ParseError)
+		if !ok || !p.layout.cfg.lenient {
+			return err
+		}
+		if err := p.skip(perr); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseBytes parses a single line into entry, which is reset beforehand. The
+// line may or may not end with a newline character, and is not read from the
+// reader of the parser. This is handy when lines come from a bufio.Scanner.
+//
+// Malformed lines are reported using a *ParseError, regardless of whether the
+// parser is lenient. The line is copied into a string, which is the one
+// allocation per line, so it can be reused after the call. The entry itself
+// is reused without further allocations, as described for ParseInto.
+func (p *Parser) ParseBytes(line []byte, entry *AccessLogEntry) error {
+	return p.layout.parseLine(entry, string(line))
+}
+
+// Skipped returns the number of malformed lines skipped so far by a lenient
+// parser.
+func (p *Parser) Skipped() int {
+	return p.skipped
+}
+
+// skip records a malformed line skipped in lenient mode.
+func (p *Parser) skip(perr *ParseError) error {
+	p.skipped++
+	return p.layout.skip(perr)
+}
+
+// parseInto reads and parses the next line into entry.
+func (p *Parser) parseInto(entry *AccessLogEntry) error {
+	line, err := p.br.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		// The last line does not need to end with a newline character.
+		return err
+	}
+	p.line++
+	offset := p.offset
+	p.offset += int64(len(line))
+
+	if err := p.layout.parseLine(entry, line); err != nil {
+		perr := err.(*ParseError)
+		perr.Line = p.line
+		perr.Offset = offset
+		return perr
+	}
+	return nil
+}
+
+// Next parses the next access log entry, which is then available through the
+// Entry method. It returns false when there are no more entries, either by
+// reaching the end of the input or because of an error. After Next returns
+// false, the Err method returns the error that occurred, if any.
+//
+// Typical usage:
+//
+//	for p.Next() {
+//		entry := p.Entry()
+//		// ...
+//	}
+//	if err := p.Err(); err != nil {
+//		// ...
+//	}
+func (p *Parser) Next() bool {
+	if p.err != nil {
+		return false
+	}
+	p.entry, p.err = p.Parse()
+	return p.err == nil
+}
+
+// Entry returns the most recent entry parsed by a call to Next.
+func (p *Parser) Entry() *AccessLogEntry {
+	return p.entry
+}
+
+// Err returns the first error encountered by Next, or nil if the end of the
+// input has been reached.
+func (p *Parser) Err() error {
+	if p.err == io.EOF {
+		return nil
+	}
+	return p.err
+}
+
+// All returns an iterator over the remaining access log entries. The error is
+// always nil, except for the last pair when an error interrupts the iteration,
+// in which case the entry is nil. Reaching the end of the input is not
+// reported as an error.
+//
+// Typical usage:
+//
+//	for entry, err := range p.All() {
+//		if err != nil {
+//			// ...
+//		}
+//		// ...
+//	}
+func (p *Parser) All() iter.Seq2[*AccessLogEntry, error] {
+	return func(yield func(*AccessLogEntry, error) bool) {
+		for p.Next() {
+			if !yield(p.Entry(), nil) {
+				return
+			}
+		}
+		if err := p.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+func parseLiteral(lit string, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		if !strings.HasPrefix(line[pos:], lit) {
+			return fmt.Errorf("got %q, want %q", line[pos:], lit)
+		}
+		return advance(next, entry, line, pos+len(lit))
+	}
+}
+
+func parseRemoteHost(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
 		entry.RemoteHost = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseRemoteLogname(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.RemoteLogname = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseRemoteUser(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		return next(entry, line, newPos)
+		entry.RemoteUser = data
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseRemoteLogname(quoted bool, next stateFn) stateFn {
+func parseTime(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readDateTime(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.RemoteLogname = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.Time = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+// parseTimeFormatted parses a time rendered by a %{...}t directive, which
+// only sets the components of the time it renders.
+func parseTimeFormatted(f field, tf timeFormat, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		var t time.Time
+		var off int
+		var err error
+		if tf.kind == timeLayout {
+			t, off, err = readTimeLayout(line, pos, f, tf.layout)
+		} else {
+			var n int64
+			n, off, err = readInt(line, pos, f)
+			switch tf.kind {
+			case timeSec:
+				t = time.Unix(n, 0).UTC()
+			case timeMsec:
+				t = time.UnixMilli(n).UTC()
+			case timeUsec:
+				t = time.UnixMicro(n).UTC()
+			case timeMsecFrac:
+				t = time.Unix(0, n*int64(time.Millisecond))
+			case timeUsecFrac:
+				t = time.Unix(0, n*int64(time.Microsecond))
+			}
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		if err != nil {
+			return err
 		}
-		return next(entry, line, newPos)
+		entry.Time = mergeTime(entry.Time, t, tf.parts)
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseRemoteUser(quoted bool, next stateFn) stateFn {
+func parseRequestFirstLine(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.RemoteUser = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.RequestFirstLine = NewRequestFirstLine(data)
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseStatus(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.Status = data
+		if code, ok := atoi(data); ok && len(data) == 3 {
+			entry.StatusCode = StatusCode(code)
 		}
-		return next(entry, line, newPos)
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseTime(quoted bool, next stateFn) stateFn {
+func parseResponseSize(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readDateTime(line, pos, quoted)
+		data, off, err := readInt(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.Time = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.ResponseSize = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseResponseSizeCLF(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		if data != "-" {
+			if entry.ResponseSize, err = strconv.ParseInt(data, 10, 64); err != nil {
+				return errors.New("malformed response size: " + err.Error())
+			}
 		}
-		return next(entry, line, newPos)
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseRequestFirstLine(quoted bool, next stateFn) stateFn {
+func parseElapsedTimeInSec(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readInt(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.RequestFirstLine = NewRequestFirstLine(data)
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.ElapsedTimeSec = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseHeader(f field, hdr string, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		if entry.Headers == nil {
+			entry.Headers = make(map[string]string)
 		}
-		return next(entry, line, newPos)
+		entry.Headers[hdr] = data
+		if data != "-" && strings.EqualFold(hdr, "Cookie") {
+			parseCookieHeader(entry, data)
+		}
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseStatus(quoted bool, next stateFn) stateFn {
+// parseCookieHeader stores the cookies of the given Cookie request header in
+// the entry. Cookies already logged using %{...}C are kept as is.
+func parseCookieHeader(entry *AccessLogEntry, header string) {
+	for _, pair := range strings.Split(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			continue
+		}
+		if entry.Cookies == nil {
+			entry.Cookies = make(map[string]string)
+		}
+		if _, found := entry.Cookies[name]; !found {
+			entry.Cookies[name] = strings.Trim(value, `"`)
+		}
+	}
+}
+
+func parseRemoteIPAddr(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.Status = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.RemoteIPAddr = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parsePeerIPAddr(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.PeerIPAddr = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+// checkIPAddr reports an error if the value at the given position is not an
+// IP address, and calls fn otherwise.
+func checkIPAddr(f field, fn stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, _, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		if !parseAddr(data).IsValid() {
+			return fmt.Errorf("invalid IP address %q", data)
 		}
-		return next(entry, line, newPos)
+		return fn(entry, line, pos)
 	}
 }
 
-func parseResponseSize(quoted bool, next stateFn) stateFn {
+func parseLocalIPAddr(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readInt(line, pos, quoted)
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.ResponseSize = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.LocalIPAddr = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseCookie(f field, name string, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		if entry.Cookies == nil {
+			entry.Cookies = make(map[string]string)
 		}
-		return next(entry, line, newPos)
+		entry.Cookies[name] = data
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseResponseSizeCLF(quoted bool, next stateFn) stateFn {
+func parseElapsedTime(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readInt(line, pos, f)
 		if err != nil {
 			return err
 		}
-		if data != "-" {
-			if entry.ResponseSize, err = strconv.ParseInt(data, 10, 64); err != nil {
-				return errors.New("malformed response size: " + err.Error())
-			}
+		entry.ElapsedTime = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseEnvVar(f field, name string, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		if entry.EnvVars == nil {
+			entry.EnvVars = make(map[string]string)
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.EnvVars[name] = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseFilename(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		return next(entry, line, newPos)
+		entry.Filename = data
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseElapsedTimeInSec(quoted bool, next stateFn) stateFn {
+func parseRequestProto(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readInt(line, pos, quoted)
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.ElapsedTimeSec = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.RequestProto = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseRequestMethod(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.RequestMethod = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parsePort(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		return next(entry, line, newPos)
+		entry.Port = data
+		return advance(next, entry, line, pos+off)
 	}
 }
 
-func parseHeader(quoted bool, hdr string, next stateFn) stateFn {
+func parseLocalPort(f field, next stateFn) stateFn {
 	return func(entry *AccessLogEntry, line string, pos int) error {
-		data, off, err := readString(line, pos, quoted)
+		data, off, err := readString(line, pos, f)
 		if err != nil {
 			return err
 		}
-		entry.Headers[hdr] = data
-		newPos := pos + off
-		if line[newPos] == ' ' {
-			newPos++ // jump over next space, if any
+		entry.LocalPort = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseRemotePort(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
 		}
-		if line[newPos] == '\n' || next == nil {
-			// If we reached the final \n character or that there is no further
-			// state, we do not call the next function.
-			return nil
+		entry.RemotePort = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseProcessID(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readInt(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.ProcessID = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseQueryString(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.QueryString = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseURLPath(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.URLPath = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseCanonicalServerName(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.CanonicalServerName = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseServerName(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.ServerName = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseBytesReceived(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readInt(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.BytesReceived = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseBytesSent(f field, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readInt(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.BytesSent = data
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+func parseThreadID(f field, base int, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readString(line, pos, f)
+		if err != nil {
+			return err
+		}
+		if entry.ThreadID, err = strconv.ParseInt(data, base, 64); err != nil {
+			return errors.New("malformed thread id: " + err.Error())
+		}
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+// parseElapsedTimeIn parses the time taken to serve the request expressed in a
+// unit of the given number of microseconds, as logged by %{ms}T or %{us}T.
+func parseElapsedTimeIn(f field, unit int64, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		data, off, err := readInt(line, pos, f)
+		if err != nil {
+			return err
+		}
+		entry.ElapsedTime = data * unit
+		return advance(next, entry, line, pos+off)
+	}
+}
+
+// parseAbsent wraps fn so that a "-" value, logged by Apache when the
+// conditions of a directive are not met, is skipped instead of being handed
+// to fn.
+func parseAbsent(f field, fn, next stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		off, ok := isAbsent(line, pos, f)
+		if !ok {
+			return fn(entry, line, pos)
 		}
-		return next(entry, line, newPos)
+		return advance(next, entry, line, pos+off)
 	}
 }
 
+// markPresent records in the entry that a value has been logged for the given
+// format, unless Apache logged a "-" instead, before calling fn.
+func markPresent(format Format, f field, fn stateFn) stateFn {
+	return func(entry *AccessLogEntry, line string, pos int) error {
+		if _, ok := isAbsent(line, pos, f); !ok {
+			entry.present |= 1 << format
+		}
+		return fn(entry, line, pos)
+	}
+}
+
+// isAbsent reports whether the value at the given position of the line is a
+// "-", which Apache logs when a value is missing. It also returns the offset
+// between the position and the character following the value.
+func isAbsent(line string, pos int, f field) (int, bool) {
+	input := line[pos:] // narrow the input to the current position
+	if f.quoted {
+		return 3, strings.HasPrefix(input, `"-"`)
+	}
+	if input == "" || input[0] != '-' {
+		return 0, false
+	}
+	rest := input[1:]
+	if f.delim != "" {
+		return 1, rest == "" || strings.HasPrefix(rest, f.delim)
+	}
+	return 1, rest == "" || rest[0] == ' ' || rest[0] == '\n'
+}
+
+// advance calls the next state function from the given position, unless there
+// is no further state. Reaching the end of the line while states remain is an
+// error, and so is text other than blanks left after the last state, such as
+// a second line joined to the first one.
+func advance(next stateFn, entry *AccessLogEntry, line string, pos int) error {
+	if next == nil {
+		// Trailing blanks are tolerated.
+		if pos < len(line) && strings.TrimRight(line[pos:], " \t") != "" {
+			return &ParseError{Column: pos + 1, Err: errors.New("unexpected trailing data")}
+		}
+		return nil
+	}
+	if pos >= len(line) {
+		return errors.New("unexpected end of line")
+	}
+	return next(entry, line, pos)
+}
+
 // extractFromQuotes extract the content of a quoted expression along with the
 // ending quote position.
 //
-// s is expected to be a non empty string starting with a " (double quote)
-// character. The closing double quote does not need to be at end of the string.
+// s is expected to start with a " (double quote) character. The closing double
+// quote does not need to be at end of the string.
 func extractFromQuotes(s string) (data string, off int, err error) {
+	if s == "" {
+		err = errors.New("missing opening quote")
+		return
+	}
 	if s[0] != '"' {
 		err = fmt.Errorf("got %q, want quote", s[0])
 		return
 	}
-	if off = strings.Index(s[1:], "\""); off == -1 {
-		err = errors.New("missing closing quote")
-	} else {
-		off++ // since we are starting from position 1
-		data = s[1:off]
+	// Embedded quotes are escaped by Apache, so the closing quote is the first
+	// one that is not preceded by a backslash.
+	for off = 1; off < len(s); off++ {
+		switch s[off] {
+		case '\\':
+			off++ // jump over the escaped character
+		case '"':
+			data = s[1:off]
+			return
+		}
 	}
+	off = -1
+	err = errors.New("missing closing quote")
 	return
 }
 
+// unescape reverts the escaping done by Apache on logged values: \" and \\
+// stand for a double quote and a backslash, \xhh for the byte of hexadecimal
+// value hh and \b, \n, \r, \t and \v for the corresponding control
+// characters. Invalid escape sequences are kept as is.
+func unescape(s string) string {
+	idx := strings.IndexByte(s, '\\')
+	if idx == -1 {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	buf = append(buf, s[:idx]...)
+	for i := idx; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			buf = append(buf, c)
+			continue
+		}
+		switch s[i+1] {
+		case '"', '\\':
+			buf = append(buf, s[i+1])
+		case 'b':
+			buf = append(buf, '\b')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'v':
+			buf = append(buf, '\v')
+		case 'x':
+			if i+3 < len(s) {
+				if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					buf = append(buf, byte(b))
+					i += 3
+					continue
+				}
+			}
+			buf = append(buf, c, s[i+1])
+		default:
+			buf = append(buf, c, s[i+1])
+		}
+		i++
+	}
+	return string(buf)
+}
+
 // readString reads the next string value from the given position of the line.
 //
+// Unquoted values end with the delimiter of the field or, if the field has
+// none or if it cannot be found, with the next space, except for the last
+// value of the line which may hold spaces, such as the User-Agent header of
+// the agent format. In any case, they do not extend past the end of the line.
+// Values are unescaped, unless the field is raw.
+//
 // It returns the string value as well as the offset between the initial
 // position and the next character following the string.
-func readString(line string, pos int, quoted bool) (data string, off int, err error) {
+func readString(line string, pos int, f field) (data string, off int, err error) {
 	input := line[pos:] // narrow the input to the current position
-	if quoted {
+	if f.quoted {
 		data, off, err = extractFromQuotes(input)
 		off++ // go after the "
 	} else {
-		if off = strings.IndexAny(input, " \n"); off == -1 {
-			// Should never happen since line is expected to have a trailing \n.
-			data = input
+		if off = strings.IndexByte(input, '\n'); off == -1 {
 			off = len(input)
-		} else {
-			data = input[:off]
 		}
+		if idx := strings.Index(input[:off], f.delim); f.delim != "" && idx != -1 {
+			off = idx
+		} else if idx := strings.IndexByte(input[:off], ' '); idx != -1 && !f.last {
+			off = idx
+		}
+		data = input[:off]
+	}
+	if !f.raw {
+		data = unescape(data)
 	}
 	return
 }
@@ -389,15 +1001,19 @@ func readString(line string, pos int, quoted bool) (data string, off int, err er
 //
 // It returns the time.Time value as well as the offset between the initial
 // position and the next character following the date.
-func readDateTime(line string, pos int, quoted bool) (d time.Time, off int, err error) {
+func readDateTime(line string, pos int, f field) (d time.Time, off int, err error) {
 	input := line[pos:] // narrow the input to the current position
-	if quoted {
+	if f.quoted {
 		if input, off, err = extractFromQuotes(input); err != nil {
 			return
 		}
+		off++ // go after the "
 	}
-	if input[0] != '[' {
-		err = fmt.Errorf("got %q, want '['", input[0])
+	if input == "" || input[0] != '[' {
+		err = errors.New("missing opening '['")
+		if input != "" {
+			err = fmt.Errorf("got %q, want '['", input[0])
+		}
 		return
 	}
 	idx := strings.Index(input, "]")
@@ -405,31 +1021,176 @@ func readDateTime(line string, pos int, quoted bool) (d time.Time, off int, err
 		err = errors.New("missing closing ']'")
 		return
 	}
-	if !quoted {
+	if !f.quoted {
 		off = idx + 1
 	}
-	if d, err = time.Parse(StandardEnglishFormat, input[1:idx]); err != nil {
+	if d, err = parseStandardEnglish(input[1:idx]); err != nil {
 		err = errors.New("failed to parse datetime: " + err.Error())
 	}
 	return
 }
 
+// readTimeLayout reads and parses the next time value, formatted according to
+// the given Go layout, from the given position of the line.
+//
+// Since the value may contain the literal text following it, such as spaces,
+// the value is first assumed to contain as many occurrences of this text as
+// the layout, and then one more, as space padded elements may add one.
+func readTimeLayout(line string, pos int, f field, layout string) (t time.Time, off int, err error) {
+	input := line[pos:] // narrow the input to the current position
+	if input == "" {
+		err = errors.New("missing time")
+		return
+	}
+	if f.quoted {
+		var value string
+		if value, off, err = extractFromQuotes(input); err != nil {
+			return
+		}
+		off++ // go after the "
+		if t, err = time.Parse(layout, value); err != nil {
+			err = errors.New("failed to parse time: " + err.Error())
+		}
+		return
+	}
+	delim := f.delim
+	if delim == "" {
+		delim = " "
+	}
+	n := strings.Count(layout, delim)
+	for i := 0; i < 2; i++ {
+		end := nthIndex(input, delim, n+i)
+		var perr error
+		if t, perr = time.Parse(layout, input[:end]); perr == nil {
+			return t, end, nil
+		}
+		if err == nil {
+			err = errors.New("failed to parse time: " + perr.Error())
+		}
+		if end == len(input) {
+			break
+		}
+	}
+	return
+}
+
+// nthIndex returns the index of the n-th occurrence, counting from 0, of sep
+// in s, or the length of s if there are not as many occurrences.
+func nthIndex(s, sep string, n int) int {
+	off := 0
+	for {
+		i := strings.Index(s[off:], sep)
+		if i == -1 {
+			return len(s)
+		}
+		if n == 0 {
+			return off + i
+		}
+		off += i + len(sep)
+		n--
+	}
+}
+
+// zones caches the locations of the timezone offsets encountered while
+// parsing dates, since time.Parse allocates a new one for each date.
+var zones sync.Map // offset in seconds -> *time.Location
+
+// parseStandardEnglish parses a date formatted according to the
+// StandardEnglishFormat layout. It is equivalent to time.Parse, except that
+// the locations are shared between the dates having the same offset.
+func parseStandardEnglish(s string) (time.Time, error) {
+	// The fast path only handles well-formed dates, time.Parse takes care of
+	// reporting meaningful errors otherwise.
+	if len(s) != len(StandardEnglishFormat) || s[2] != '/' || s[6] != '/' ||
+		s[11] != ':' || s[14] != ':' || s[17] != ':' || s[20] != ' ' ||
+		(s[21] != '+' && s[21] != '-') {
+		return time.Parse(StandardEnglishFormat, s)
+	}
+	day, ok1 := atoi(s[0:2])
+	year, ok2 := atoi(s[7:11])
+	hour, ok3 := atoi(s[12:14])
+	min, ok4 := atoi(s[15:17])
+	sec, ok5 := atoi(s[18:20])
+	zoneHour, ok6 := atoi(s[22:24])
+	zoneMin, ok7 := atoi(s[24:26])
+	month := lookupMonth(s[3:6])
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 || !ok7 || month == 0 ||
+		hour > 23 || min > 59 || sec > 59 || zoneMin > 59 {
+		return time.Parse(StandardEnglishFormat, s)
+	}
+
+	offset := zoneHour*3600 + zoneMin*60
+	if s[21] == '-' {
+		offset = -offset
+	}
+	loc, ok := zones.Load(offset)
+	if !ok {
+		loc, _ = zones.LoadOrStore(offset, time.FixedZone("", offset))
+	}
+
+	t := time.Date(year, month, day, hour, min, sec, 0, loc.(*time.Location))
+	if t.Day() != day {
+		// The day is out of range and has been normalized by time.Date.
+		return time.Parse(StandardEnglishFormat, s)
+	}
+	return t, nil
+}
+
+// atoi parses a short unsigned decimal number.
+func atoi(s string) (n int, ok bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, true
+}
+
+// lookupMonth returns the month of the given English abbreviation, or 0 if the
+// abbreviation is unknown.
+func lookupMonth(s string) time.Month {
+	for m := time.January; m <= time.December; m++ {
+		if m.String()[:3] == s {
+			return m
+		}
+	}
+	return 0
+}
+
 // readInt reads the next integer value from the given position of the line.
 //
 // It returns the 64 integer value as well as the offset between the initial
 // position and the next character following the integer.
-func readInt(line string, pos int, quoted bool) (data int64, off int, err error) {
+func readInt(line string, pos int, f field) (data int64, off int, err error) {
 	input := line[pos:] // narrow the input to the current position
-	if input[0] < '0' || input[0] > '9' {
-		err = fmt.Errorf("got %q, want digit between 0 and 9", input[0])
+	if f.quoted {
+		if input, off, err = extractFromQuotes(input); err != nil {
+			return
+		}
+		off++ // go after the "
+	}
+	if input == "" || input[0] < '0' || input[0] > '9' {
+		err = errors.New("missing digit")
+		if input != "" {
+			err = fmt.Errorf("got %q, want digit between 0 and 9", input[0])
+		}
 		return
 	}
-	for off = 1; off < len(input); off++ {
-		if input[off] < '0' || input[off] > '9' {
+	n := 1
+	for ; n < len(input); n++ {
+		if input[n] < '0' || input[n] > '9' {
 			break
 		}
 	}
+	if f.quoted && n != len(input) {
+		err = fmt.Errorf("got %q, want digit between 0 and 9", input[n])
+		return
+	}
+	if !f.quoted {
+		off = n
+	}
 	// error should not occur since only digit are kept
-	data, err = strconv.ParseInt(input[:off], 10, 64)
+	data, err = strconv.ParseInt(input[:n], 10, 64)
 	return
 }
@@ -4,21 +4,23 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 )
 
 var makeStateFnTests = []struct {
-	expr []string // input
-	err  error    // expected error
+	expr []token // input
+	err  error   // expected error
 }{
 	{
-		expr: []string{"%h"},
+		expr: []token{{typ: tokenDirective, val: "%h"}},
 		err:  nil,
 	},
 	{
-		expr: []string{},
+		expr: []token{},
 		err:  nil,
 	},
 	{
@@ -26,18 +28,22 @@ var makeStateFnTests = []struct {
 		err:  nil,
 	},
 	{
-		expr: []string{"foo"},
-		err:  errors.New("\"foo\" format is not supported"),
+		expr: []token{{typ: tokenLiteral, val: "foo"}},
+		err:  nil,
+	},
+	{
+		expr: []token{{typ: tokenDirective, val: "%x"}},
+		err:  errors.New("\"%x\" format is not supported"),
 	},
 	{
-		expr: []string{"%h", "foo"},
-		err:  errors.New("\"foo\" format is not supported"),
+		expr: []token{{typ: tokenDirective, val: "%h"}, {typ: tokenLiteral, val: " "}, {typ: tokenDirective, val: "%x"}},
+		err:  errors.New("\"%x\" format is not supported"),
 	},
 }
 
 func TestMakeStateFn(t *testing.T) {
 	for _, test := range makeStateFnTests {
-		_, err := makeStateFn(test.expr)
+		_, err := makeStateFn(test.expr, config{})
 		switch {
 		case err == nil && test.err != nil:
 			t.Errorf("makeSateFn(%v): expected error %q; got none", test.expr, test.err.Error())
@@ -84,6 +90,505 @@ func TestCombinedParser(t *testing.T) {
 	t.Logf("%#v", entry)
 }
 
+func TestCustomParser(t *testing.T) {
+	format := `%v %a %A %p %P %m %U %q %H %f %D %I %O %{SESSID}C %{UNIQUE_ID}e %V`
+	logLine := `www.example.com 10.0.0.1 10.0.0.2 443 1234 GET /index.php ?id=1 HTTP/1.1 /var/www/index.php 5012 420 8123 abcdef XyZ42 example.com`
+
+	t.Log("input = ", logLine)
+
+	p, err := CustomParser(strings.NewReader(logLine+"\n"), format)
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := AccessLogEntry{
+		CanonicalServerName: "www.example.com",
+		RemoteIPAddr:        "10.0.0.1",
+		LocalIPAddr:         "10.0.0.2",
+		Port:                "443",
+		ProcessID:           1234,
+		RequestMethod:       "GET",
+		URLPath:             "/index.php",
+		QueryString:         "?id=1",
+		RequestProto:        "HTTP/1.1",
+		Filename:            "/var/www/index.php",
+		ElapsedTime:         5012,
+		BytesReceived:       420,
+		BytesSent:           8123,
+		Cookies:             map[string]string{"SESSID": "abcdef"},
+		EnvVars:             map[string]string{"UNIQUE_ID": "XyZ42"},
+		Headers:             map[string]string{},
+		ServerName:          "example.com",
+	}
+	for _, f := range []Format{
+		CANONICAL_SERVER_NAME, REMOTE_IP_ADDRESS, LOCAL_IP_ADDRESS, PORT,
+		PROCESS_ID, REQUEST_METHOD, URL_PATH, QUERY_STRING, REQUEST_PROTO,
+		FILENAME, ELAPSED_TIME, BYTES_RECEIVED, BYTES_SENT, COOKIE, ENV_VAR,
+		SERVER_NAME,
+	} {
+		if !entry.Has(f) {
+			t.Errorf("CustomParser(%q).Parse(): Has(%v) is false; want true", format, f)
+		}
+	}
+	entry.present = 0 // checked above
+	if !reflect.DeepEqual(*entry, want) {
+		t.Errorf("CustomParser(%q).Parse(): got %#v; want %#v", format, *entry, want)
+	}
+}
+
+func TestCustomParser_modifiers(t *testing.T) {
+	format := `%h %l %u %t "%r" %>s %b %{ms}T %{hextid}P "%{Referer}i" "%400,501{User-agent}i"`
+	logLine := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 12 7f3a "http://www.example.com/start.html" "-"`
+
+	p, err := CustomParser(strings.NewReader(logLine+"\n"), format)
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entry.Status, "200"; got != want {
+		t.Errorf("CustomParser(%q).Parse(): got Status %q; want %q", format, got, want)
+	}
+	if got, want := entry.ElapsedTime, int64(12000); got != want {
+		t.Errorf("CustomParser(%q).Parse(): got ElapsedTime %d; want %d", format, got, want)
+	}
+	if got, want := entry.ThreadID, int64(0x7f3a); got != want {
+		t.Errorf("CustomParser(%q).Parse(): got ThreadID %d; want %d", format, got, want)
+	}
+	if got, want := entry.Headers["Referer"], "http://www.example.com/start.html"; got != want {
+		t.Errorf("CustomParser(%q).Parse(): got Referer %q; want %q", format, got, want)
+	}
+	if got, found := entry.Headers["User-agent"]; found {
+		t.Errorf("CustomParser(%q).Parse(): got User-agent %q; want none", format, got)
+	}
+}
+
+func TestCustomParser_time(t *testing.T) {
+	want := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	testCases := []struct {
+		format string
+		line   string
+		want   time.Time
+	}{
+		{`%h %{%d/%b/%Y:%H:%M:%S %z}t %>s`, `127.0.0.1 10/Oct/2000:13:55:36 -0700 200`, want},
+		{`%h [%{begin:%d/%b/%Y %T}t.%{msec_frac}t %{%z}t] %>s`, `127.0.0.1 [10/Oct/2000 13:55:36.042 -0700] 200`, want.Add(42 * time.Millisecond)},
+		{`%h %{%FT%T}t.%{usec_frac}t%{%z}t %>s`, `127.0.0.1 2000-10-10T13:55:36.000042-0700 200`, want.Add(42 * time.Microsecond)},
+		{`%h "%{%c}t" %>s`, `127.0.0.1 "Tue Oct 10 13:55:36 2000" 200`, time.Date(2000, time.October, 10, 13, 55, 36, 0, time.UTC)},
+		{`%h %{%c}t %>s`, `127.0.0.1 Mon Oct  2 13:55:36 2000 200`, time.Date(2000, time.October, 2, 13, 55, 36, 0, time.UTC)},
+		{`%h %{sec}t %>s`, `127.0.0.1 971211336 200`, want.UTC()},
+		{`%h %{end:msec}t %>s`, `127.0.0.1 971211336042 200`, want.Add(42 * time.Millisecond).UTC()},
+		{`%h %{usec}t %>s`, `127.0.0.1 971211336000042 200`, want.Add(42 * time.Microsecond).UTC()},
+	}
+	for _, tc := range testCases {
+		l, err := Compile(tc.format)
+		if err != nil {
+			t.Errorf("Compile(%q): unexpected error %q", tc.format, err.Error())
+			continue
+		}
+		entry, err := l.ParseLine(tc.line)
+		if err != nil {
+			t.Errorf("Compile(%q).ParseLine(%q): unexpected error %q", tc.format, tc.line, err.Error())
+			continue
+		}
+		if !entry.Time.Equal(tc.want) {
+			t.Errorf("Compile(%q).ParseLine(%q): got Time %v; want %v", tc.format, tc.line, entry.Time, tc.want)
+		}
+		_, got := entry.Time.Zone()
+		if _, offset := tc.want.Zone(); got != offset {
+			t.Errorf("Compile(%q).ParseLine(%q): got offset %d; want %d", tc.format, tc.line, got, offset)
+		}
+		if entry.Status != "200" {
+			t.Errorf("Compile(%q).ParseLine(%q): got Status %q; want %q", tc.format, tc.line, entry.Status, "200")
+		}
+	}
+
+	for _, format := range []string{"%{%Q}t", "%{%Y 1}t", "%{%d Jan}t", "%{%Y%}t"} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("Compile(%q): expected error; got none", format)
+		}
+	}
+}
+
+func TestCustomParser_variants(t *testing.T) {
+	format := "%a %{c}a %{canonical}p %{local}p %{remote}p"
+	logLine := "192.0.2.1 10.0.0.5 80 8080 51234"
+	entry, err := MustCompile(format).ParseLine(logLine)
+	if err != nil {
+		t.Fatalf("Compile(%q).ParseLine(%q): unexpected error %q", format, logLine, err.Error())
+	}
+	want := AccessLogEntry{
+		RemoteIPAddr: "192.0.2.1",
+		PeerIPAddr:   "10.0.0.5",
+		Port:         "80",
+		LocalPort:    "8080",
+		RemotePort:   "51234",
+		Cookies:      map[string]string{},
+		EnvVars:      map[string]string{},
+		Headers:      map[string]string{},
+		present:      1<<REMOTE_IP_ADDRESS | 1<<PORT,
+	}
+	if !reflect.DeepEqual(*entry, want) {
+		t.Errorf("Compile(%q).ParseLine(%q): got %#v; want %#v", format, logLine, *entry, want)
+	}
+}
+
+func TestCustomParser_literals(t *testing.T) {
+	format := "%h:%p [%{X-Id}i] \"%{X Forwarded For}i\" %D\t%>s"
+	logLine := "10.0.0.1:8080 [abc 123] \"192.168.1.1, 10.0.0.3\" 5012\t404"
+
+	p, err := CustomParser(strings.NewReader(logLine+"\n"), format)
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := AccessLogEntry{
+		RemoteHost:  "10.0.0.1",
+		Port:        "8080",
+		Headers:     map[string]string{"X-Id": "abc 123", "X Forwarded For": "192.168.1.1, 10.0.0.3"},
+		Cookies:     map[string]string{},
+		EnvVars:     map[string]string{},
+		ElapsedTime: 5012,
+		Status:      "404",
+		StatusCode:  404,
+		present:     1<<REMOTE_HOST | 1<<PORT | 1<<HEADER | 1<<ELAPSED_TIME | 1<<STATUS,
+	}
+	if !reflect.DeepEqual(*entry, want) {
+		t.Errorf("CustomParser(%q).Parse(): got %#v; want %#v", format, *entry, want)
+	}
+
+	p, err = CustomParser(strings.NewReader("10.0.0.1:8080 {abc 123}\n"), format)
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	if _, err := p.Parse(); err == nil {
+		t.Errorf("CustomParser(%q).Parse(): expected error for mismatching separator; got none", format)
+	}
+}
+
+func TestParser_Next(t *testing.T) {
+	logLines := `127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /a HTTP/1.1" 200 1
+127.0.0.1 - - [12/Dec/2016:10:57:31 +0100] "GET /b HTTP/1.1" 200 2
+127.0.0.1 - - [12/Dec/2016 10:57:32] "GET /c HTTP/1.1" 200 3
+127.0.0.1 - - [12/Dec/2016:10:57:33 +0100] "GET /d HTTP/1.1" 200 4
+`
+	p, err := CommonParser(strings.NewReader(logLines))
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	var sizes []int64
+	for p.Next() {
+		sizes = append(sizes, p.Entry().ResponseSize)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Next(): got sizes %v; want %v", sizes, want)
+	}
+	if p.Err() == nil {
+		t.Error("Err(): expected error; got none")
+	}
+	if p.Next() {
+		t.Error("Next(): got true after an error; want false")
+	}
+
+	p, err = CommonParser(strings.NewReader(logLines[:strings.Index(logLines, "127.0.0.1 - - [12/Dec/2016 ")]))
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	var n int
+	for _, err := range p.All() {
+		if err != nil {
+			t.Fatalf("All(): unexpected error %q", err.Error())
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("All(): got %d entries; want 2", n)
+	}
+}
+
+func TestParser_lastLine(t *testing.T) {
+	logLines := "127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] \"GET /a HTTP/1.1\" 200 1\r\n" +
+		"127.0.0.1 - - [12/Dec/2016:10:57:31 +0100] \"GET /b HTTP/1.1\" 200 2"
+
+	p, err := CommonParser(strings.NewReader(logLines))
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	var sizes []int64
+	for p.Next() {
+		sizes = append(sizes, p.Entry().ResponseSize)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err(): unexpected error %q", err.Error())
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Next(): got sizes %v; want %v", sizes, want)
+	}
+}
+
+func TestParser_ParseError(t *testing.T) {
+	line1 := `127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /a HTTP/1.1" 200 1` + "\n"
+	line2 := `127.0.0.1 - - [12/Dec/2016 10:57:31] "GET /b HTTP/1.1" 200 2`
+
+	p, err := CommonParser(strings.NewReader(line1 + line2 + "\n"))
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	if _, err := p.Parse(); err != nil {
+		t.Fatalf("Parse(): unexpected error %q", err.Error())
+	}
+	_, err = p.Parse()
+
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Parse(): got error %v; want a *ParseError", err)
+	}
+	want := ParseError{
+		Line:      2,
+		Column:    15,
+		Offset:    int64(len(line1)),
+		Directive: "%t",
+		Format:    TIME,
+		Raw:       line2,
+		Err:       perr.Err,
+	}
+	if *perr != want {
+		t.Errorf("Parse(): got error %+v; want %+v", *perr, want)
+	}
+	if got, want := perr.Error(), "line 2, column 15: %t: "+perr.Err.Error(); got != want {
+		t.Errorf("ParseError.Error(): got %q; want %q", got, want)
+	}
+}
+
+func TestParser_lenient(t *testing.T) {
+	logLines := `127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /a HTTP/1.1" 200 1
+127.0.0.1 - - [12/Dec/2016 10:57:31] "GET /b HTTP/1.1" 200 2
+127.0.0.1 - - [12/Dec/2016:10:57:32 +0100] "GET /c HTTP/1.1" 200 3
+127.0.0.1 - - [12/Dec/2016:10:57:33 +0100] "GET /d HTTP
+`
+	var deadLetter bytes.Buffer
+	var lines []int
+	p, err := CommonParser(strings.NewReader(logLines),
+		DeadLetter(&deadLetter),
+		OnSkip(func(err *ParseError) { lines = append(lines, err.Line) }),
+	)
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	var sizes []int64
+	for p.Next() {
+		sizes = append(sizes, p.Entry().ResponseSize)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err(): unexpected error %q", err.Error())
+	}
+	if want := []int64{1, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Next(): got sizes %v; want %v", sizes, want)
+	}
+	if want := []int{2, 4}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("OnSkip(...): got lines %v; want %v", lines, want)
+	}
+	if got, want := p.Skipped(), 2; got != want {
+		t.Errorf("Skipped(): got %d; want %d", got, want)
+	}
+	split := strings.Split(logLines, "\n")
+	want := split[1] + "\n" + split[3] + "\n"
+	if got := deadLetter.String(); got != want {
+		t.Errorf("DeadLetter(...): got %q; want %q", got, want)
+	}
+}
+
+func TestParser_truncated(t *testing.T) {
+	complete := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"`
+	logLines := strings.Join([]string{
+		complete,
+		"127.0.0.1 - fra",
+		`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200`,
+		`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/"`,
+		"",
+		complete + complete,
+		complete + " ",
+		complete,
+	}, "\n") + "\n"
+	var lines []int
+	p, err := CombinedParser(strings.NewReader(logLines), Lenient(),
+		OnSkip(func(err *ParseError) { lines = append(lines, err.Line) }),
+	)
+	if err != nil {
+		t.Fatalf("CombinedParser(...): unexpected error %q", err.Error())
+	}
+	var n int
+	for p.Next() {
+		n++
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err(): unexpected error %q", err.Error())
+	}
+	if n != 3 {
+		t.Errorf("Next(): got %d entries; want 3", n)
+	}
+	if want := []int{2, 3, 4, 5, 6}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("OnSkip(...): got lines %v; want %v", lines, want)
+	}
+	if got, want := p.Skipped(), 5; got != want {
+		t.Errorf("Skipped(): got %d; want %d", got, want)
+	}
+
+	layout := MustCompile("%h")
+	if _, err := layout.ParseLine(""); err == nil {
+		t.Errorf("ParseLine(\"\") with format %q: expected error; got none", "%h")
+	}
+
+	// Lines joined without newline character are reported where the second
+	// one starts.
+	_, err = MustCompile(CombinedLogFormat).ParseLine(complete + complete)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("ParseLine(...): got error %v; want a *ParseError", err)
+	}
+	if got, want := perr.Column, len(complete)+1; got != want {
+		t.Errorf("ParseLine(...): got column %d; want %d", got, want)
+	}
+}
+
+func TestParser_lastValue(t *testing.T) {
+	entry, err := MustCompile(AgentLogFormat).ParseLine("Mozilla/5.0 (X11; Linux x86_64)")
+	if err != nil {
+		t.Fatalf("ParseLine(...): unexpected error %q", err.Error())
+	}
+	if got, want := entry.Headers["User-agent"], "Mozilla/5.0 (X11; Linux x86_64)"; got != want {
+		t.Errorf("ParseLine(...): got User-agent %q; want %q", got, want)
+	}
+}
+
+func TestParser_blankLine(t *testing.T) {
+	p, err := CustomParser(strings.NewReader("\"GET / HTTP/1.1\" 200\n\n"), "\"%r\" %>s", Lenient())
+	if err != nil {
+		t.Fatalf("CustomParser(...): unexpected error %q", err.Error())
+	}
+	var n int
+	for p.Next() {
+		n++
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err(): unexpected error %q", err.Error())
+	}
+	if n != 1 {
+		t.Errorf("Next(): got %d entries; want 1", n)
+	}
+	if got, want := p.Skipped(), 1; got != want {
+		t.Errorf("Skipped(): got %d; want %d", got, want)
+	}
+
+	for _, format := range []string{"\"%r\" %>s", "%{%d/%b/%Y}t %>s", "[%{%d/%b/%Y}t] %>s"} {
+		layout := MustCompile(format)
+		if _, err := layout.ParseLine(""); err == nil {
+			t.Errorf("ParseLine(\"\") with format %q: expected error; got none", format)
+		}
+	}
+}
+
+func TestCustomParser_escapes(t *testing.T) {
+	logLine := `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\"b HTTP/1.0" 200 2326 "-" "Mozilla \"compatible\" \\o/\x7f" ` + "\n"
+
+	p, err := CombinedParser(strings.NewReader(logLine))
+	if err != nil {
+		t.Fatalf("CombinedParser(...): unexpected error %q", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entry.RequestFirstLine.String(), `GET /a"b HTTP/1.0`; got != want {
+		t.Errorf("got RequestFirstLine %q; want %q", got, want)
+	}
+	if got, want := entry.Headers["User-agent"], "Mozilla \"compatible\" \\o/\x7f"; got != want {
+		t.Errorf("got User-agent %q; want %q", got, want)
+	}
+
+	p, err = CombinedParser(strings.NewReader(logLine), KeepRaw())
+	if err != nil {
+		t.Fatalf("CombinedParser(...): unexpected error %q", err.Error())
+	}
+	if entry, err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entry.Headers["User-agent"], `Mozilla \"compatible\" \\o/\x7f`; got != want {
+		t.Errorf("KeepRaw(): got User-agent %q; want %q", got, want)
+	}
+}
+
+func TestParser_ParseInto(t *testing.T) {
+	logLines := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a HTTP/1.0" 200 2326 "http://www.example.com/" "Mozilla/4.08"
+127.0.0.2 - - [10/Oct/2000:13:55:37 -0700] "GET /b HTTP/1.0" 404 - "-" "curl/7.50"
+`
+	p, err := CombinedParser(strings.NewReader(logLines))
+	if err != nil {
+		t.Fatalf("CombinedParser(...): unexpected error %q", err.Error())
+	}
+
+	var entry AccessLogEntry
+	if err := p.ParseInto(&entry); err != nil {
+		t.Fatal(err)
+	}
+	headers := entry.Headers
+	if err := p.ParseInto(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entry.RemoteUser, "-"; got != want {
+		t.Errorf("ParseInto(...): got RemoteUser %q; want %q", got, want)
+	}
+	if got, want := entry.ResponseSize, int64(0); got != want {
+		t.Errorf("ParseInto(...): got ResponseSize %d; want %d", got, want)
+	}
+	if got, want := entry.Headers["User-agent"], "curl/7.50"; got != want {
+		t.Errorf("ParseInto(...): got User-agent %q; want %q", got, want)
+	}
+	if reflect.ValueOf(entry.Headers).Pointer() != reflect.ValueOf(headers).Pointer() {
+		t.Error("ParseInto(...): headers map has been reallocated")
+	}
+	if err := p.ParseInto(&entry); err != io.EOF {
+		t.Errorf("ParseInto(...): got error %v; want io.EOF", err)
+	}
+}
+
+func TestParser_ParseBytes(t *testing.T) {
+	p, err := CommonParser(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("CommonParser(...): unexpected error %q", err.Error())
+	}
+	line := []byte(`127.0.0.1 - - [12/Dec/2016:10:57:30 +0100] "GET /a HTTP/1.1" 200 50122`)
+
+	var entry AccessLogEntry
+	if err := p.ParseBytes(line, &entry); err != nil {
+		t.Fatal(err)
+	}
+	copy(line, "xxxxxxxxx")
+	if got, want := entry.RemoteHost, "127.0.0.1"; got != want {
+		t.Errorf("ParseBytes(...): got RemoteHost %q; want %q", got, want)
+	}
+	if got, want := entry.ResponseSize, int64(50122); got != want {
+		t.Errorf("ParseBytes(...): got ResponseSize %d; want %d", got, want)
+	}
+	if entry.Headers != nil {
+		t.Errorf("ParseBytes(...): got Headers %v; want nil", entry.Headers)
+	}
+
+	var perr *ParseError
+	if err := p.ParseBytes([]byte("127.0.0.1 - - foo"), &entry); !errors.As(err, &perr) {
+		t.Errorf("ParseBytes(...): got error %v; want a *ParseError", err)
+	}
+}
+
 func TestParseRemoteHost(t *testing.T) {
 	type testCase struct {
 		quoted bool
@@ -96,25 +601,27 @@ func TestParseRemoteHost(t *testing.T) {
 		{quoted: false, line: "foobar 42", err: nil},
 	}
 
+	// The rest of the line is left to the next state function.
+	next := func(entry *AccessLogEntry, line string, pos int) error { return nil }
 	for i, test := range testCases {
 		var entry AccessLogEntry
-		err := parseRemoteHost(test.quoted, nil)(&entry, test.line, 0)
+		err := parseRemoteHost(field{quoted: test.quoted}, next)(&entry, test.line, 0)
 		switch {
 		case err == nil && test.err != nil:
-			t.Errorf("%d. parseRemoteHost(%v, nil)({}, %q, 0): expected error %q; got none",
+			t.Errorf("%d. parseRemoteHost(%v, next)({}, %q, 0): expected error %q; got none",
 				i, test.quoted, test.line, test.err.Error())
 			continue
 		case err != nil && test.err == nil:
-			t.Errorf("%d. parseRemoteHost(%v, nil)({}, %q, 0): unexpected error %q",
-				i, test.quoted, test.line, test.err.Error())
+			t.Errorf("%d. parseRemoteHost(%v, next)({}, %q, 0): unexpected error %q",
+				i, test.quoted, test.line, err.Error())
 			continue
 		case err != nil && test.err != nil && err.Error() != test.err.Error():
-			t.Errorf("%d. parseRemoteHost(%v, nil)({}, %q, 0): expected error %q; got %q",
+			t.Errorf("%d. parseRemoteHost(%v, next)({}, %q, 0): expected error %q; got %q",
 				i, test.quoted, test.line, test.err.Error(), err.Error())
 			continue
 		}
 		if got := entry.RemoteHost; got != "foobar" {
-			t.Errorf("%d. parseRemoteHost(%v, nil)({}, %q, 0): expected RemoteHost %q, got %q",
+			t.Errorf("%d. parseRemoteHost(%v, next)({}, %q, 0): expected RemoteHost %q, got %q",
 				i, test.quoted, test.line, "foobar", got)
 		}
 	}
@@ -131,6 +638,9 @@ func TestExtractFromQuotes(t *testing.T) {
 		{in: "\"foo\" bar", out: "foo", off: 4, err: nil},
 		{in: "\"foo bar", out: "", off: -1, err: errors.New("missing closing quote")},
 		{in: "foo\" bar", out: "", off: 0, err: fmt.Errorf("got 'f', want quote")},
+		{in: `"say \"hi\" \\" bar`, out: `say \"hi\" \\`, off: 14, err: nil},
+		{in: `"foo\" bar`, out: "", off: -1, err: errors.New("missing closing quote")},
+		{in: "", out: "", off: 0, err: errors.New("missing opening quote")},
 	}
 
 	for i, test := range testCases {
@@ -155,6 +665,26 @@ func TestExtractFromQuotes(t *testing.T) {
 	}
 }
 
+func TestUnescape(t *testing.T) {
+	type testCase struct {
+		in, out string
+	}
+
+	testCases := []testCase{
+		{in: "foo", out: "foo"},
+		{in: `say \"hi\"`, out: `say "hi"`},
+		{in: `C:\\Windows`, out: `C:\Windows`},
+		{in: `\x41\xe9\n\t`, out: "A\xe9\n\t"},
+		{in: `\xzz \q \`, out: `\xzz \q \`},
+	}
+
+	for i, test := range testCases {
+		if got := unescape(test.in); got != test.out {
+			t.Errorf("%d. unescape(%q): got %q; want %q", i, test.in, got, test.out)
+		}
+	}
+}
+
 func TestReadString(t *testing.T) {
 	type testCase struct {
 		in, out  string
@@ -171,7 +701,7 @@ func TestReadString(t *testing.T) {
 	}
 
 	for i, test := range testCases {
-		data, off, err := readString(test.in, test.pos, test.quoted)
+		data, off, err := readString(test.in, test.pos, field{quoted: test.quoted})
 		if data != test.out {
 			t.Errorf("%d. readString(%q) got data %q, want %q", i, test.in, data, test.out)
 		}
@@ -219,7 +749,7 @@ func TestReadDateTime(t *testing.T) {
 			in:     "\"[16/Nov/2016:09:25:05 +0100]\" foobar",
 			out:    time.Date(2016, 11, 16, 9, 25, 5, 0, tz),
 			quoted: true,
-			off:    29,
+			off:    30,
 			err:    nil,
 		},
 
@@ -234,7 +764,7 @@ func TestReadDateTime(t *testing.T) {
 	}
 
 	for i, test := range testCases {
-		d, off, err := readDateTime(test.in, test.pos, test.quoted)
+		d, off, err := readDateTime(test.in, test.pos, field{quoted: test.quoted})
 		if !d.Equal(test.out) {
 			t.Errorf("%d. readDateTime(%q) got date %q, want %q",
 				i, test.in, d.Format(StandardEnglishFormat), test.out.Format(StandardEnglishFormat))
@@ -256,6 +786,36 @@ func TestReadDateTime(t *testing.T) {
 	}
 }
 
+func TestParseStandardEnglish(t *testing.T) {
+	inputs := []string{
+		"16/Nov/2016:09:25:05 +0100",
+		"29/Feb/2016:23:59:59 -0730",
+		"01/Jan/2000:00:00:00 +0000",
+		"30/Feb/2016:09:25:05 +0100",
+		"16/Foo/2016:09:25:05 +0100",
+		"16/Nov/2016:24:25:05 +0100",
+		"16/Nov/2016:09:25:05 0100",
+		"2016-11-16 09:25:05 +0100",
+	}
+
+	for i, in := range inputs {
+		want, wantErr := time.Parse(StandardEnglishFormat, in)
+		got, err := parseStandardEnglish(in)
+		if (err == nil) != (wantErr == nil) {
+			t.Errorf("%d. parseStandardEnglish(%q): got error %v; want %v", i, in, err, wantErr)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%d. parseStandardEnglish(%q): got %v; want %v", i, in, got, want)
+		}
+		_, gotOff := got.Zone()
+		_, wantOff := want.Zone()
+		if gotOff != wantOff {
+			t.Errorf("%d. parseStandardEnglish(%q): got offset %d; want %d", i, in, gotOff, wantOff)
+		}
+	}
+}
+
 func TestReadInt(t *testing.T) {
 	type testCase struct {
 		in       string
@@ -273,7 +833,7 @@ func TestReadInt(t *testing.T) {
 	}
 
 	for i, test := range testCases {
-		data, off, err := readInt(test.in, test.pos, test.quoted)
+		data, off, err := readInt(test.in, test.pos, field{quoted: test.quoted})
 		if data != test.out {
 			t.Errorf("%d. readInt(%q) got data %d, want %d", i, test.in, data, test.out)
 		}
@@ -310,3 +870,52 @@ func BenchmarkCommonParser(b *testing.B) {
 		}
 	}
 }
+
+var benchLine = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"` + "\n"
+
+func BenchmarkParser_Parse(b *testing.B) {
+	r := strings.NewReader(strings.Repeat(benchLine, b.N))
+	p, err := CombinedParser(r)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		if _, err := p.Parse(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParser_ParseInto(b *testing.B) {
+	r := strings.NewReader(strings.Repeat(benchLine, b.N))
+	p, err := CombinedParser(r)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var entry AccessLogEntry
+	b.ReportAllocs()
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		if err := p.ParseInto(&entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParser_ParseBytes(b *testing.B) {
+	p, err := CombinedParser(strings.NewReader(""))
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := []byte(benchLine)
+	var entry AccessLogEntry
+	b.ReportAllocs()
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		if err := p.ParseBytes(line, &entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
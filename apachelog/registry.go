@@ -0,0 +1,120 @@
+package apachelog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Most commonly used log formats.
+const (
+	CombinedLogFormat = "%h %l %u %t \"%r\" %s %b \"%{Referer}i\" \"%{User-agent}i\""
+	CommonLogFormat   = "%h %l %u %t \"%r\" %s %b"
+
+	// Virtual host variants of the Common and Combined Log formats, as
+	// defined by the Apache documentation and by Debian based distributions.
+	VHostCommonLogFormat   = "%v %h %l %u %t \"%r\" %>s %b"
+	VHostCombinedLogFormat = "%v:%p %h %l %u %t \"%r\" %>s %O \"%{Referer}i\" \"%{User-Agent}i\""
+
+	// CombinedIOLogFormat is the Combined Log format extended with the bytes
+	// received and sent, as logged when mod_logio is enabled.
+	CombinedIOLogFormat = "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\" %I %O"
+
+	// Formats of the separate referer and agent logs of the NCSA server.
+	RefererLogFormat = "%{Referer}i -> %U"
+	AgentLogFormat   = "%{User-agent}i"
+
+	// NCSAExtendedCookieLogFormat is the NCSA extended log format, that is
+	// the Combined Log format, followed by the cookies sent by the client.
+	NCSAExtendedCookieLogFormat = "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-agent}i\" \"%{Cookie}i\""
+)
+
+// CombinedLogFromat is the Apache Combined Log format.
+//
+// Deprecated: Use CombinedLogFormat instead.
+const CombinedLogFromat = CombinedLogFormat
+
+// registry holds the named formats, in registration order.
+var registry = struct {
+	sync.RWMutex
+	names   []string
+	formats map[string]string
+}{
+	formats: make(map[string]string),
+}
+
+func init() {
+	for _, f := range []struct{ name, format string }{
+		{"combined", CombinedLogFormat},
+		{"common", CommonLogFormat},
+		{"vhost_combined", VHostCombinedLogFormat},
+		{"vhost_common", VHostCommonLogFormat},
+		{"combinedio", CombinedIOLogFormat},
+		{"ncsa_extended", CombinedLogFormat},
+		{"ncsa_extended_cookie", NCSAExtendedCookieLogFormat},
+		{"referer", RefererLogFormat},
+		{"agent", AgentLogFormat},
+	} {
+		if err := Register(f.name, f.format); err != nil {
+			panic("apachelog: " + err.Error())
+		}
+	}
+}
+
+// Register makes a format available by the provided name, as the LogFormat
+// directive of Apache does with nicknames. Registering a name again replaces
+// its format. An error is returned if the format is not supported.
+func Register(name, format string) error {
+	if _, err := Compile(format); err != nil {
+		return fmt.Errorf("format %q: %v", name, err)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.formats[name]; !ok {
+		registry.names = append(registry.names, name)
+	}
+	registry.formats[name] = format
+	return nil
+}
+
+// unregister removes the format registered under the given name, if any.
+func unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.formats[name]; !ok {
+		return
+	}
+	delete(registry.formats, name)
+	for i, n := range registry.names {
+		if n == name {
+			registry.names = append(registry.names[:i], registry.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// NamedFormat returns the format registered under the given name.
+func NamedFormat(name string) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	format, ok := registry.formats[name]
+	return format, ok
+}
+
+// FormatNames returns the names of the registered formats, in registration
+// order.
+func FormatNames() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]string(nil), registry.names...)
+}
+
+// ParserByName creates a new parser that reads from r and that parses log
+// entries using the format registered under the given name.
+func ParserByName(r io.Reader, name string, opts ...Option) (*Parser, error) {
+	format, ok := NamedFormat(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown log format name %q", name)
+	}
+	return CustomParser(r, format, opts...)
+}
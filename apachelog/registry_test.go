@@ -0,0 +1,92 @@
+package apachelog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamedFormat(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format string
+	}{
+		{"common", CommonLogFormat},
+		{"combined", CombinedLogFormat},
+		{"vhost_common", VHostCommonLogFormat},
+		{"vhost_combined", VHostCombinedLogFormat},
+		{"combinedio", CombinedIOLogFormat},
+		{"referer", RefererLogFormat},
+		{"agent", AgentLogFormat},
+		{"ncsa_extended", CombinedLogFormat},
+		{"ncsa_extended_cookie", NCSAExtendedCookieLogFormat},
+	}
+	for _, tc := range testCases {
+		got, ok := NamedFormat(tc.name)
+		if !ok {
+			t.Errorf("NamedFormat(%q): not found", tc.name)
+			continue
+		}
+		if got != tc.format {
+			t.Errorf("NamedFormat(%q): got %q, want %q", tc.name, got, tc.format)
+		}
+	}
+	if _, ok := NamedFormat("unknown"); ok {
+		t.Errorf("NamedFormat(%q): expected not found", "unknown")
+	}
+	if CombinedLogFromat != CombinedLogFormat {
+		t.Errorf("CombinedLogFromat: got %q, want %q", CombinedLogFromat, CombinedLogFormat)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register("test_invalid", "%h %x"); err == nil {
+		t.Errorf("Register(%q): expected error; got none", "%h %x")
+	}
+	if _, ok := NamedFormat("test_invalid"); ok {
+		t.Errorf("NamedFormat(%q): invalid format has been registered", "test_invalid")
+	}
+
+	t.Cleanup(func() { unregister("test_short") })
+	for _, format := range []string{"%h %u", "%h %>s"} {
+		if err := Register("test_short", format); err != nil {
+			t.Fatalf("Register(%q): unexpected error %q", format, err.Error())
+		}
+	}
+	var n int
+	for _, name := range FormatNames() {
+		if name == "test_short" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("FormatNames(): got %d occurrences of %q, want 1", n, "test_short")
+	}
+
+	p, err := ParserByName(strings.NewReader("127.0.0.1 404\n"), "test_short")
+	if err != nil {
+		t.Fatalf("ParserByName(%q): unexpected error %q", "test_short", err.Error())
+	}
+	entry, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse(): unexpected error %q", err.Error())
+	}
+	if entry.Status != "404" {
+		t.Errorf("Parse(): got Status %q, want %q", entry.Status, "404")
+	}
+
+	unregister("test_short")
+	if _, ok := NamedFormat("test_short"); ok {
+		t.Errorf("NamedFormat(%q): found after unregister", "test_short")
+	}
+	for _, name := range FormatNames() {
+		if name == "test_short" {
+			t.Errorf("FormatNames(): got %q after unregister", name)
+		}
+	}
+}
+
+func TestParserByName_unknown(t *testing.T) {
+	if _, err := ParserByName(strings.NewReader(""), "unknown"); err == nil {
+		t.Errorf("ParserByName(%q): expected error; got none", "unknown")
+	}
+}
@@ -0,0 +1,52 @@
+package apachelog
+
+import "strconv"
+
+// A StatusCode is the HTTP status code of the response to a request. The zero
+// value means that the status has not been logged.
+type StatusCode int
+
+// IsSet reports whether the status has been logged.
+func (c StatusCode) IsSet() bool {
+	return c != 0
+}
+
+// Class returns the class of the status, that is its first digit, such as 2
+// for the 2xx successful responses. It returns 0 if the status is not set.
+func (c StatusCode) Class() int {
+	return int(c) / 100
+}
+
+// IsInformational reports whether the status is a 1xx informational one.
+func (c StatusCode) IsInformational() bool {
+	return c.Class() == 1
+}
+
+// IsSuccess reports whether the status is a 2xx successful one.
+func (c StatusCode) IsSuccess() bool {
+	return c.Class() == 2
+}
+
+// IsRedirect reports whether the status is a 3xx redirection one.
+func (c StatusCode) IsRedirect() bool {
+	return c.Class() == 3
+}
+
+// IsClientError reports whether the status is a 4xx client error one.
+func (c StatusCode) IsClientError() bool {
+	return c.Class() == 4
+}
+
+// IsServerError reports whether the status is a 5xx server error one.
+func (c StatusCode) IsServerError() bool {
+	return c.Class() == 5
+}
+
+// String returns the status as logged by Apache, that is a "-" if it is not
+// set.
+func (c StatusCode) String() string {
+	if c == 0 {
+		return "-"
+	}
+	return strconv.Itoa(int(c))
+}
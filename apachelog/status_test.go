@@ -0,0 +1,69 @@
+package apachelog
+
+import "testing"
+
+func TestStatusCode(t *testing.T) {
+	testCases := []struct {
+		code  StatusCode
+		set   bool
+		class int
+		str   string
+	}{
+		{0, false, 0, "-"},
+		{100, true, 1, "100"},
+		{200, true, 2, "200"},
+		{304, true, 3, "304"},
+		{404, true, 4, "404"},
+		{503, true, 5, "503"},
+	}
+	for _, tc := range testCases {
+		if got := tc.code.IsSet(); got != tc.set {
+			t.Errorf("StatusCode(%d).IsSet(): got %t; want %t", tc.code, got, tc.set)
+		}
+		if got := tc.code.Class(); got != tc.class {
+			t.Errorf("StatusCode(%d).Class(): got %d; want %d", tc.code, got, tc.class)
+		}
+		if got := tc.code.String(); got != tc.str {
+			t.Errorf("StatusCode(%d).String(): got %q; want %q", tc.code, got, tc.str)
+		}
+		checks := []struct {
+			name string
+			got  bool
+		}{
+			{"IsInformational", tc.code.IsInformational()},
+			{"IsSuccess", tc.code.IsSuccess()},
+			{"IsRedirect", tc.code.IsRedirect()},
+			{"IsClientError", tc.code.IsClientError()},
+			{"IsServerError", tc.code.IsServerError()},
+		}
+		for i, check := range checks {
+			if want := i+1 == tc.class; check.got != want {
+				t.Errorf("StatusCode(%d).%s(): got %t; want %t", tc.code, check.name, check.got, want)
+			}
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	testCases := []struct {
+		line   string
+		status string
+		code   StatusCode
+	}{
+		{"127.0.0.1 200", "200", 200},
+		{"127.0.0.1 503", "503", 503},
+		{"127.0.0.1 -", "-", 0},
+		{"127.0.0.1 2000", "2000", 0},
+	}
+	l := MustCompile("%h %>s")
+	for _, tc := range testCases {
+		entry, err := l.ParseLine(tc.line)
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error %q", tc.line, err.Error())
+			continue
+		}
+		if entry.Status != tc.status || entry.StatusCode != tc.code {
+			t.Errorf("ParseLine(%q): got %q and %d; want %q and %d", tc.line, entry.Status, entry.StatusCode, tc.status, tc.code)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package apachelog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeKind identifies how a %{...}t directive renders the time.
+type timeKind int
+
+const (
+	timeLayout   timeKind = iota // strftime(3) format
+	timeSec                      // seconds since the epoch
+	timeMsec                     // milliseconds since the epoch
+	timeUsec                     // microseconds since the epoch
+	timeMsecFrac                 // millisecond fraction
+	timeUsecFrac                 // microsecond fraction
+)
+
+// timeParts is a set of components of a time, as rendered by a %{...}t
+// directive.
+type timeParts uint8
+
+const (
+	timeDate  timeParts = 1 << iota // year, month and day
+	timeClock                       // hours, minutes and seconds
+	timeFrac                        // fraction of a second
+	timeZone                        // timezone offset
+
+	timeAll = timeDate | timeClock | timeFrac | timeZone
+)
+
+// A timeFormat describes the parameter of a %{...}t directive.
+type timeFormat struct {
+	kind   timeKind
+	layout string    // Go layout equivalent to the strftime(3) format
+	parts  timeParts // components of the time rendered by the directive
+}
+
+// parseTimeFormat parses the parameter of a %{...}t directive. The begin: and
+// end: prefixes are accepted but ignored, since a single time is recorded per
+// entry.
+func parseTimeFormat(param string) (timeFormat, error) {
+	param = strings.TrimPrefix(param, "begin:")
+	param = strings.TrimPrefix(param, "end:")
+	switch param {
+	case "sec":
+		return timeFormat{kind: timeSec, parts: timeAll}, nil
+	case "msec":
+		return timeFormat{kind: timeMsec, parts: timeAll}, nil
+	case "usec":
+		return timeFormat{kind: timeUsec, parts: timeAll}, nil
+	case "msec_frac":
+		return timeFormat{kind: timeMsecFrac, parts: timeFrac}, nil
+	case "usec_frac":
+		return timeFormat{kind: timeUsecFrac, parts: timeFrac}, nil
+	}
+	layout, parts, err := strftimeLayout(param)
+	if err != nil {
+		return timeFormat{}, err
+	}
+	return timeFormat{kind: timeLayout, layout: layout, parts: parts}, nil
+}
+
+// strftimeConversions maps the strftime(3) conversion specifications to their
+// Go layout equivalent and to the components of the time they render.
+var strftimeConversions = map[byte]struct {
+	layout string
+	parts  timeParts
+}{
+	'a': {"Mon", 0},
+	'A': {"Monday", 0},
+	'b': {"Jan", timeDate},
+	'B': {"January", timeDate},
+	'c': {"Mon Jan _2 15:04:05 2006", timeDate | timeClock},
+	'd': {"02", timeDate},
+	'D': {"01/02/06", timeDate},
+	'e': {"_2", timeDate},
+	'F': {"2006-01-02", timeDate},
+	'h': {"Jan", timeDate},
+	'H': {"15", timeClock},
+	'I': {"03", timeClock},
+	'j': {"002", timeDate},
+	'm': {"01", timeDate},
+	'M': {"04", timeClock},
+	'n': {"\n", 0},
+	'p': {"PM", timeClock},
+	'r': {"03:04:05 PM", timeClock},
+	'R': {"15:04", timeClock},
+	'S': {"05", timeClock},
+	't': {"\t", 0},
+	'T': {"15:04:05", timeClock},
+	'x': {"01/02/06", timeDate},
+	'X': {"15:04:05", timeClock},
+	'y': {"06", timeDate},
+	'Y': {"2006", timeDate},
+	'z': {"-0700", timeZone},
+	'Z': {"MST", timeZone},
+	'%': {"%", 0},
+}
+
+// strftimeLayout translates a strftime(3) format into the equivalent Go time
+// layout, and returns the components of the time the format renders.
+//
+// Go layouts cannot escape literal text, so an error is returned if a literal
+// of the format would be mistaken for a layout element, as well as for the
+// conversion specifications that have no Go equivalent.
+func strftimeLayout(format string) (string, timeParts, error) {
+	var b strings.Builder
+	var parts timeParts
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			end := strings.IndexByte(format[i:], '%')
+			if end == -1 {
+				end = len(format) - i
+			}
+			lit := format[i : i+end]
+			if strings.ContainsAny(lit, "0123456789") || containsLayoutElement(lit) {
+				return "", 0, fmt.Errorf("time format %q: literal %q is not supported", format, lit)
+			}
+			b.WriteString(lit)
+			i += end - 1
+			continue
+		}
+		if i+1 == len(format) {
+			return "", 0, fmt.Errorf("time format %q ends with an incomplete conversion", format)
+		}
+		i++
+		conv, ok := strftimeConversions[format[i]]
+		if !ok {
+			return "", 0, fmt.Errorf("time format %q: %%%c is not supported", format, format[i])
+		}
+		b.WriteString(conv.layout)
+		parts |= conv.parts
+	}
+	return b.String(), parts, nil
+}
+
+// containsLayoutElement reports whether s contains a word that Go time layouts
+// interpret, such as a month or a weekday name.
+func containsLayoutElement(s string) bool {
+	for _, elem := range [...]string{"Jan", "Mon", "MST", "PM", "pm", "Z07"} {
+		if strings.Contains(s, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTime returns cur with the given components replaced by those of t.
+// Several %{...}t directives may contribute to the time of an entry, such as
+// "%{%d/%b/%Y %T}t.%{msec_frac}t %{%z}t".
+func mergeTime(cur, t time.Time, parts timeParts) time.Time {
+	if parts == timeAll {
+		return t
+	}
+	year, month, day := cur.Date()
+	hour, min, sec := cur.Clock()
+	nsec, loc := cur.Nanosecond(), cur.Location()
+	if parts&timeDate != 0 {
+		year, month, day = t.Date()
+	}
+	if parts&timeClock != 0 {
+		hour, min, sec = t.Clock()
+	}
+	if parts&timeFrac != 0 {
+		nsec = t.Nanosecond()
+	}
+	if parts&timeZone != 0 {
+		loc = t.Location()
+	}
+	return time.Date(year, month, day, hour, min, sec, nsec, loc)
+}
@@ -0,0 +1,56 @@
+package apachelog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftimeLayout(t *testing.T) {
+	testCases := []struct {
+		in     string
+		layout string
+		parts  timeParts
+	}{
+		{"%d/%b/%Y:%H:%M:%S %z", "02/Jan/2006:15:04:05 -0700", timeDate | timeClock | timeZone},
+		{"%FT%T", "2006-01-02T15:04:05", timeDate | timeClock},
+		{"%a, %e %B %y", "Mon, _2 January 06", timeDate},
+		{"%r %Z", "03:04:05 PM MST", timeClock | timeZone},
+		{"%%%n", "%\n", 0},
+	}
+	for _, tc := range testCases {
+		layout, parts, err := strftimeLayout(tc.in)
+		if err != nil {
+			t.Errorf("strftimeLayout(%q): unexpected error %q", tc.in, err.Error())
+			continue
+		}
+		if layout != tc.layout || parts != tc.parts {
+			t.Errorf("strftimeLayout(%q): got %q and %b; want %q and %b", tc.in, layout, parts, tc.layout, tc.parts)
+		}
+	}
+
+	for _, in := range []string{"%s", "%Y%", "day 1: %d", "%H PM"} {
+		if _, _, err := strftimeLayout(in); err == nil {
+			t.Errorf("strftimeLayout(%q): expected error; got none", in)
+		}
+	}
+}
+
+func TestMergeTime(t *testing.T) {
+	zone := time.FixedZone("", 3600)
+	cur := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.UTC)
+	testCases := []struct {
+		t     time.Time
+		parts timeParts
+		want  time.Time
+	}{
+		{time.Unix(0, 42e6), timeFrac, time.Date(2000, time.October, 10, 13, 55, 36, 42e6, time.UTC)},
+		{time.Date(0, time.January, 1, 0, 0, 0, 0, zone), timeZone, time.Date(2000, time.October, 10, 13, 55, 36, 0, zone)},
+		{time.Date(2017, time.May, 2, 8, 0, 0, 0, time.UTC), timeDate, time.Date(2017, time.May, 2, 13, 55, 36, 0, time.UTC)},
+		{time.Date(2017, time.May, 2, 8, 0, 0, 0, zone), timeAll, time.Date(2017, time.May, 2, 8, 0, 0, 0, zone)},
+	}
+	for _, tc := range testCases {
+		if got := mergeTime(cur, tc.t, tc.parts); !got.Equal(tc.want) || got.Location() != tc.want.Location() {
+			t.Errorf("mergeTime(%v, %v, %b): got %v; want %v", cur, tc.t, tc.parts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/e-XpertSolutions/go-apachelog
+
+go 1.23